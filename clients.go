@@ -0,0 +1,211 @@
+package gomicro
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gidyon/gomicro/pkg/conn"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// clientDialTimeout bounds lazy dials of client connections
+const clientDialTimeout = 30 * time.Second
+
+// clientConn is a named connection to a downstream gRPC service
+type clientConn struct {
+	name   string
+	opt    *conn.GrpcDialOptions
+	lazy   bool
+	dialMu sync.Mutex
+	mu     sync.Mutex
+	cc     *grpc.ClientConn
+	closed bool
+}
+
+// AddClientConn declares a downstream gRPC dependency by name.
+//
+// Eager connections are dialed when the service is initialized while lazy connections are dialed on first use.
+// The service client interceptors are applied to the connection, and the connection is closed when the service shuts down.
+func (service *Service) AddClientConn(name string, opt *conn.GrpcDialOptions, lazy bool) error {
+	service.clientConnsMu.Lock()
+	defer service.clientConnsMu.Unlock()
+
+	switch {
+	case name == "":
+		return fmt.Errorf("missing client connection name")
+	case opt == nil:
+		return fmt.Errorf("nil dial options for client connection %s", name)
+	}
+
+	if _, ok := service.clientConns[name]; ok {
+		return fmt.Errorf("client connection %s already registered", name)
+	}
+
+	if opt.ServiceName == "" {
+		opt.ServiceName = name
+	}
+
+	service.clientConns[name] = &clientConn{name: name, opt: opt, lazy: lazy}
+
+	return nil
+}
+
+// ClientConnByName returns the shared connection registered with AddClientConn, dialing it if necessary.
+//
+// A failed dial is retried on the next call.
+func (service *Service) ClientConnByName(name string) (*grpc.ClientConn, error) {
+	service.clientConnsMu.Lock()
+	cc, ok := service.clientConns[name]
+	service.clientConnsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("client connection %s not registered", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clientDialTimeout)
+	defer cancel()
+
+	return cc.dial(ctx, service)
+}
+
+// ClientConnStates returns the connectivity state of every dialed client connection
+func (service *Service) ClientConnStates() map[string]connectivity.State {
+	service.clientConnsMu.Lock()
+	defer service.clientConnsMu.Unlock()
+
+	states := make(map[string]connectivity.State, len(service.clientConns))
+	for name, cc := range service.clientConns {
+		if c := cc.conn(); c != nil {
+			states[name] = c.GetState()
+		}
+	}
+
+	return states
+}
+
+// clientDialOptions returns dial options that apply the service client interceptors
+func (service *Service) clientDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(service.unaryClientInterceptors...),
+		grpc.WithChainStreamInterceptor(service.streamClientInterceptors...),
+	}
+}
+
+// dial returns the connection, dialing it if it was not dialed yet or the previous dial failed
+func (cc *clientConn) dial(ctx context.Context, service *Service) (*grpc.ClientConn, error) {
+	// Serialize dials without blocking readers of the connection
+	cc.dialMu.Lock()
+	defer cc.dialMu.Unlock()
+
+	cc.mu.Lock()
+	c, closed := cc.cc, cc.closed
+	cc.mu.Unlock()
+
+	switch {
+	case closed:
+		return nil, fmt.Errorf("client connection %s is closed", cc.name)
+	case c != nil:
+		return c, nil
+	}
+
+	opt := *cc.opt
+	opt.DialOptions = append(service.clientDialOptions(), cc.opt.DialOptions...)
+	if opt.Logger == nil {
+		opt.Logger = service.options.Logger
+	}
+
+	c, err := conn.DialGrpcService(ctx, &opt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s client connection: %v", cc.name, err)
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.closed {
+		_ = c.Close()
+		return nil, fmt.Errorf("client connection %s is closed", cc.name)
+	}
+
+	cc.cc = c
+
+	return c, nil
+}
+
+// conn returns the connection or nil when it is not dialed
+func (cc *clientConn) conn() *grpc.ClientConn {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.cc
+}
+
+// close closes the connection, later dials fail
+func (cc *clientConn) close() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.closed = true
+	if cc.cc == nil {
+		return nil
+	}
+	return cc.cc.Close()
+}
+
+// initClientConns dials eager client connections and registers their readiness checks and shutdowns
+func (service *Service) initClientConns(ctx context.Context) error {
+	service.clientConnsMu.Lock()
+	ccs := make([]*clientConn, 0, len(service.clientConns))
+	for _, cc := range service.clientConns {
+		ccs = append(ccs, cc)
+	}
+	service.clientConnsMu.Unlock()
+
+	sort.Slice(ccs, func(i, j int) bool {
+		return ccs[i].name < ccs[j].name
+	})
+
+	for _, cc := range ccs {
+		if cc.lazy {
+			continue
+		}
+		if _, err := cc.dial(ctx, service); err != nil {
+			return err
+		}
+	}
+
+	service.AddReadinessChecks(service.checkClientConns)
+	service.shutdowns = append(service.shutdowns, service.closeClientConns)
+
+	return nil
+}
+
+// checkClientConns fails if any dialed client connection is in a failed or shutdown state
+func (service *Service) checkClientConns(ctx context.Context) error {
+	for name, state := range service.ClientConnStates() {
+		switch state {
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return fmt.Errorf("client connection %s is in %s state", name, state)
+		}
+	}
+	return nil
+}
+
+// closeClientConns closes all dialed client connections
+func (service *Service) closeClientConns() error {
+	service.clientConnsMu.Lock()
+	defer service.clientConnsMu.Unlock()
+
+	var err error
+	for name, cc := range service.clientConns {
+		if err2 := cc.close(); err2 != nil && err == nil {
+			err = fmt.Errorf("failed to close %s client connection: %v", name, err2)
+		}
+	}
+
+	return err
+}
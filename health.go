@@ -0,0 +1,40 @@
+package gomicro
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// AddReadinessChecks adds checks that must pass for the service to be ready to serve requests
+func (service *Service) AddReadinessChecks(checks ...func(context.Context) error) {
+	service.readinessChecks = append(service.readinessChecks, checks...)
+}
+
+// Ready runs the readiness checks returning the first error encountered
+func (service *Service) Ready(ctx context.Context) error {
+	for _, check := range service.readinessChecks {
+		if err := check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadinessHandler returns a http handler that responds with 503 status when the service is not ready.
+//
+// Register it using AddEndpoint, for example on /readyz.
+func (service *Service) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if err := service.Ready(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+}
@@ -1,6 +1,7 @@
 package gomicro
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -31,6 +32,11 @@ type Service struct {
 	httpMux                  *http.ServeMux
 	runtimeMux               *runtime.ServeMux
 	shutdowns                []func() error
+	readinessChecks          []func(context.Context) error
+	startupHooks             []func(context.Context) error
+	workers                  []func(context.Context) error
+	clientConns              map[string]*clientConn
+	clientConnsMu            sync.Mutex
 	initOnceFn               *sync.Once
 	runOnceFn                *sync.Once
 	nowFunc                  func() time.Time
@@ -54,7 +60,7 @@ type Options struct {
 
 // NewService create a micro-service utility store by parsing data from config. Pass nil logger to use default logger
 func NewService(opt *Options) (*Service, error) {
-	if opt.Logger != nil {
+	if opt.Logger == nil {
 		opt.Logger = NewLogger("app", zerolog.TraceLevel)
 	}
 
@@ -73,6 +79,10 @@ func NewService(opt *Options) (*Service, error) {
 		unaryClientInterceptors:  make([]grpc.UnaryClientInterceptor, 0),
 		streamClientInterceptors: make([]grpc.StreamClientInterceptor, 0),
 		shutdowns:                make([]func() error, 0),
+		readinessChecks:          make([]func(context.Context) error, 0),
+		startupHooks:             make([]func(context.Context) error, 0),
+		workers:                  make([]func(context.Context) error, 0),
+		clientConns:              make(map[string]*clientConn),
 		initOnceFn:               &sync.Once{},
 		runOnceFn:                &sync.Once{},
 		nowFunc:                  opt.NowFunc,
@@ -98,6 +108,13 @@ func (service *Service) AddEndpointFunc(pattern string, handleFunc http.HandlerF
 	service.httpMux.HandleFunc(pattern, handleFunc)
 }
 
+// AddStartupHooks adds functions that are run in order when the service is initialized, before it starts serving.
+//
+// A failing hook aborts startup, for example running database migrations with migrate.Migrator Up.
+func (service *Service) AddStartupHooks(hooks ...func(context.Context) error) {
+	service.startupHooks = append(service.startupHooks, hooks...)
+}
+
 // AddHTTPMiddlewares adds http middlewares to the service
 func (service *Service) AddHTTPMiddlewares(middlewares ...func(http.Handler) http.Handler) {
 	service.httpMiddlewares = append(service.httpMiddlewares, middlewares...)
@@ -171,6 +188,11 @@ func (service *Service) ClientConn() *grpc.ClientConn {
 	return service.clientConn
 }
 
+// Logger returns the service logger
+func (service *Service) Logger() grpclog.LoggerV2 {
+	return service.options.Logger
+}
+
 // GRPCServer returns the grpc server for the service
 func (service *Service) GRPCServer() *grpc.Server {
 	return service.gRPCServer
@@ -0,0 +1,127 @@
+package conn
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/balancer/roundrobin"
+)
+
+// Load balancing policies that can be selected per target in GrpcDialOptions
+const (
+	RoundRobin         = roundrobin.Name
+	PickFirst          = "pick_first"
+	WeightedRoundRobin = "gomicro_weighted_round_robin"
+	LeastRequest       = "gomicro_least_request"
+)
+
+// weightKey is the resolver address balancer attribute key holding an endpoint weight
+type weightKey struct{}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(WeightedRoundRobin, &wrrPickerBuilder{}, base.Config{}))
+	balancer.Register(base.NewBalancerBuilder(LeastRequest, &lrPickerBuilder{}, base.Config{}))
+}
+
+func subConnWeight(info base.SubConnInfo) int {
+	if w, ok := info.Address.BalancerAttributes.Value(weightKey{}).(uint32); ok && w > 0 {
+		return int(w)
+	}
+	return 1
+}
+
+type wrrPickerBuilder struct{}
+
+func (*wrrPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	entries := make([]*wrrEntry, 0, len(info.ReadySCs))
+	for sc, scInfo := range info.ReadySCs {
+		entries = append(entries, &wrrEntry{subConn: sc, weight: subConnWeight(scInfo)})
+	}
+
+	return &wrrPicker{entries: entries}
+}
+
+type wrrEntry struct {
+	subConn balancer.SubConn
+	weight  int
+	current int
+}
+
+// wrrPicker picks subconns using smooth weighted round-robin
+type wrrPicker struct {
+	mu      sync.Mutex
+	entries []*wrrEntry
+}
+
+func (p *wrrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		total int
+		best  *wrrEntry
+	)
+
+	for _, entry := range p.entries {
+		entry.current += entry.weight
+		total += entry.weight
+		if best == nil || entry.current > best.current {
+			best = entry
+		}
+	}
+
+	best.current -= total
+
+	return balancer.PickResult{SubConn: best.subConn}, nil
+}
+
+type lrPickerBuilder struct{}
+
+func (*lrPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	entries := make([]*lrEntry, 0, len(info.ReadySCs))
+	for sc := range info.ReadySCs {
+		entries = append(entries, &lrEntry{subConn: sc})
+	}
+
+	return &lrPicker{entries: entries}
+}
+
+type lrEntry struct {
+	subConn  balancer.SubConn
+	inflight int64
+}
+
+// lrPicker picks the subconn with fewer outstanding requests out of two random choices
+type lrPicker struct {
+	entries []*lrEntry
+}
+
+func (p *lrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	entry := p.entries[rand.Intn(len(p.entries))]
+	if len(p.entries) > 1 {
+		other := p.entries[rand.Intn(len(p.entries))]
+		if atomic.LoadInt64(&other.inflight) < atomic.LoadInt64(&entry.inflight) {
+			entry = other
+		}
+	}
+
+	atomic.AddInt64(&entry.inflight, 1)
+
+	return balancer.PickResult{
+		SubConn: entry.subConn,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(&entry.inflight, -1)
+		},
+	}, nil
+}
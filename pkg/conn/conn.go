@@ -4,15 +4,22 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/grpclog"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm/logger"
 
 	"gorm.io/gorm"
 
-	// Imports mysql driver
-	_ "github.com/go-sql-driver/mysql"
+	// Imports postgres driver
+	_ "github.com/jackc/pgx/v4/stdlib"
+	// Imports sqlite driver
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // DbPoolSettings contains options for customizing the connection pool
@@ -32,8 +39,61 @@ type DbOptions struct {
 	Password string
 	Schema   string
 	ConnPool *DbPoolSettings
+
+	// ReplicaAddresses are addresses of read replicas sharing the other options with the primary at Address
+	ReplicaAddresses []string
+	// ReplicaHealthInterval is how often replica health is checked, defaults to 10 seconds
+	ReplicaHealthInterval time.Duration
+
+	// Connection parameters, see DbOptions.dsn for how they map to each dialect
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	TimeZone       string
+	Collation      string
+	TLS            *DbTLSOptions
+	Params         map[string]string
+
+	// GormConfig is passed to gorm when opening the database.
+	// Its logger defaults to a gorm logger over Logger when set or a silent logger otherwise.
+	GormConfig *gorm.Config
+
+	// Retry retries the connection until the database is reachable
+	Retry *DbRetrySettings
+	// Logger logs connection retries and failed or slow queries
+	Logger grpclog.LoggerV2
+
+	// EnableMetrics exports pool and query metrics labeled by Name, which is then required
+	EnableMetrics bool
+	// MetricsRegisterer registers the metrics, defaults to prometheus.DefaultRegisterer
+	MetricsRegisterer prometheus.Registerer
+}
+
+// DbTLSOptions contains TLS settings for database connections
+type DbTLSOptions struct {
+	// Mode is one of disable, require, verify-ca or verify-full
+	Mode       string
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
 }
 
+// TLS modes for database connections
+const (
+	TLSModeDisable    = "disable"
+	TLSModeRequire    = "require"
+	TLSModeVerifyCA   = "verify-ca"
+	TLSModeVerifyFull = "verify-full"
+)
+
+// Supported database dialects
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
 // OpenGorm open a connection to sql database using gorm orm
 func OpenGorm(opt *DbOptions) (*gorm.DB, error) {
 	return openGorm(opt)
@@ -41,55 +101,78 @@ func OpenGorm(opt *DbOptions) (*gorm.DB, error) {
 
 // opens a connection to SQL database returning gorm database client
 func openGorm(opt *DbOptions) (*gorm.DB, error) {
-	// Options should not be nil
-	if opt == nil {
-		return nil, errors.New("nil db options not allowed")
+	sqlDB, err := open(opt)
+	if err != nil {
+		return nil, err
 	}
 
-	// add MySQL driver specific parameter to parse date/time
-	param := "charset=utf8mb4&parseTime=True"
-
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
-		opt.User,
-		opt.Password,
-		opt.Address,
-		opt.Schema,
-		param,
-	)
-
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("(GORM) failed to open connection to mysql database [name=%s] [address=%s] : %v ", opt.Name, opt.Address, err)
+	gormConfig := &gorm.Config{}
+	if opt.GormConfig != nil {
+		*gormConfig = *opt.GormConfig
+	}
+	if gormConfig.Logger == nil {
+		if opt.Logger != nil {
+			gormConfig.Logger = NewGormLogger(opt.Logger, nil)
+		} else {
+			gormConfig.Logger = logger.Default.LogMode(logger.Silent)
+		}
 	}
 
-	sqlDB, err := db.DB()
+	db, err := gorm.Open(opt.gormDialector(sqlDB), gormConfig)
 	if err != nil {
-		return nil, err
+		sqlDB.Close()
+		return nil, fmt.Errorf("(GORM) failed to open connection to %s database [name=%s] [address=%s] : %v ", opt.dialect(), opt.Name, opt.Address, err)
 	}
 
-	if opt.ConnPool != nil {
-		if opt.ConnPool.MaxIdleConns != 0 {
-			sqlDB.SetMaxIdleConns(int(opt.ConnPool.MaxIdleConns))
+	if opt.EnableMetrics {
+		err = db.Use(&GormMetrics{DBName: opt.Name, Registerer: opt.metricsRegisterer()})
+		if err != nil {
+			sqlDB.Close()
+			return nil, fmt.Errorf("failed to register gorm metrics: %v", err)
 		}
-		if opt.ConnPool.MaxOpenConns != 0 {
-			sqlDB.SetMaxOpenConns(int(opt.ConnPool.MaxOpenConns))
-		}
-		if opt.ConnPool.MaxLifetime != 0 {
-			sqlDB.SetConnMaxLifetime(opt.ConnPool.MaxLifetime)
-		}
-		if opt.ConnPool.MaxIdleLifetime != 0 {
-			sqlDB.SetConnMaxIdleTime(opt.ConnPool.MaxIdleLifetime)
+	}
+
+	if len(opt.ReplicaAddresses) > 0 {
+		err = useReplicas(db, sqlDB, opt)
+		if err != nil {
+			sqlDB.Close()
+			return nil, err
 		}
 	}
 
+	if err := opt.registerDBStats(sqlDB); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// gormDialector returns the gorm dialector for the dialect using sqlDB as the connection pool
+func (opt *DbOptions) gormDialector(sqlDB *sql.DB) gorm.Dialector {
+	switch opt.dialect() {
+	case DialectPostgres:
+		return postgres.New(postgres.Config{Conn: sqlDB})
+	case DialectSQLite:
+		return &sqlite.Dialector{Conn: sqlDB}
+	default:
+		return mysql.New(mysql.Config{Conn: sqlDB})
+	}
+}
+
 // OpenSql open a connection to sql database
 func OpenSql(opt *DbOptions) (*sql.DB, error) {
-	return open(opt)
+	sqlDB, err := open(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := opt.registerDBStats(sqlDB); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	return sqlDB, nil
 }
 
 // opens a connection to the SQL database returning sql database client
@@ -99,27 +182,18 @@ func open(opt *DbOptions) (*sql.DB, error) {
 		return nil, errors.New("nil db options not allowed")
 	}
 
-	// add MySQL driver specific parameter to parse date/time
-	param := "charset=utf8&parseTime=true"
-
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
-		opt.User,
-		opt.Password,
-		opt.Address,
-		opt.Schema,
-		param,
-	)
+	if opt.EnableMetrics && opt.Name == "" {
+		return nil, errors.New("missing db name for metrics")
+	}
 
-	dialect := func() string {
-		if opt.Dialect == "" {
-			return "mysql"
-		}
-		return opt.Dialect
-	}()
+	driverName, dsn, err := opt.dsn()
+	if err != nil {
+		return nil, err
+	}
 
-	sqlDB, err := sql.Open(dialect, dsn)
+	sqlDB, err := sql.Open(driverName, dsn)
 	if err != nil {
-		return nil, fmt.Errorf("(SQL) failed to open connection to mysql database [name=%s] [address=%s]: %v", opt.Name, opt.Address, err)
+		return nil, fmt.Errorf("(SQL) failed to open connection to %s database [name=%s] [address=%s]: %v", opt.dialect(), opt.Name, opt.Address, err)
 	}
 
 	if opt.ConnPool != nil {
@@ -132,7 +206,49 @@ func open(opt *DbOptions) (*sql.DB, error) {
 		if opt.ConnPool.MaxLifetime != 0 {
 			sqlDB.SetConnMaxLifetime(opt.ConnPool.MaxLifetime)
 		}
+		if opt.ConnPool.MaxIdleLifetime != 0 {
+			sqlDB.SetConnMaxIdleTime(opt.ConnPool.MaxIdleLifetime)
+		}
+	}
+
+	if opt.Retry != nil {
+		err = opt.Retry.ping(sqlDB, opt)
+		if err != nil {
+			sqlDB.Close()
+			return nil, fmt.Errorf("(SQL) failed to connect to %s database [name=%s] [address=%s]: %v", opt.dialect(), opt.Name, opt.Address, err)
+		}
 	}
 
 	return sqlDB, nil
 }
+
+// registerDBStats exports the pool statistics of sqlDB once it is connected
+func (opt *DbOptions) registerDBStats(sqlDB *sql.DB) error {
+	if !opt.EnableMetrics {
+		return nil
+	}
+	if err := registerDBStats(opt.metricsRegisterer(), opt.Name, sqlDB); err != nil {
+		return fmt.Errorf("failed to register db stats metrics: %v", err)
+	}
+	return nil
+}
+
+func (opt *DbOptions) metricsRegisterer() prometheus.Registerer {
+	if opt.MetricsRegisterer == nil {
+		return prometheus.DefaultRegisterer
+	}
+	return opt.MetricsRegisterer
+}
+
+func (opt *DbOptions) dialect() string {
+	switch dialect := strings.ToLower(opt.Dialect); dialect {
+	case "":
+		return DialectMySQL
+	case "postgresql", "pgx":
+		return DialectPostgres
+	case "sqlite3":
+		return DialectSQLite
+	default:
+		return dialect
+	}
+}
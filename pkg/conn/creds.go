@@ -0,0 +1,139 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TokenSource returns a bearer token together with the time it expires.
+//
+// A zero expiry means the token never expires and is never refreshed.
+type TokenSource func(ctx context.Context) (token string, expires time.Time, err error)
+
+// StaticToken returns a TokenSource that always yields the same token
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, time.Time, error) {
+		return token, time.Time{}, nil
+	}
+}
+
+// tokenCredentials is a credentials.PerRPCCredentials that attaches a bearer token to every RPC,
+// refreshing the token from its source shortly before it expires.
+type tokenCredentials struct {
+	source     TokenSource
+	requireTLS bool
+	mu         sync.Mutex
+	token      string
+	fetched    time.Time
+	expires    time.Time
+}
+
+// NewTokenCredentials creates per-RPC credentials that attach tokens from source as bearer authorization metadata.
+//
+// Tokens are cached and refreshed once less than a fifth of their lifetime remains.
+func NewTokenCredentials(source TokenSource, requireTLS bool) credentials.PerRPCCredentials {
+	return &tokenCredentials{source: source, requireTLS: requireTLS}
+}
+
+func (tc *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := tc.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (tc *tokenCredentials) RequireTransportSecurity() bool {
+	return tc.requireTLS
+}
+
+func (tc *tokenCredentials) getToken(ctx context.Context) (string, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.token != "" && !tc.shouldRefresh(time.Now()) {
+		return tc.token, nil
+	}
+
+	token, expires, err := tc.source(ctx)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("failed to get token for rpc credentials: %v", err)
+	case token == "":
+		return "", errors.New("token source returned empty token")
+	}
+
+	tc.token, tc.fetched, tc.expires = token, time.Now(), expires
+
+	return tc.token, nil
+}
+
+func (tc *tokenCredentials) shouldRefresh(now time.Time) bool {
+	if tc.expires.IsZero() {
+		return false
+	}
+	return tc.expires.Sub(now) < tc.expires.Sub(tc.fetched)/5
+}
+
+// transportCredentials creates transport credentials from the TLS settings in opt.
+//
+// It returns nil credentials when opt has no transport settings so that credentials passed in DialOptions are used.
+func transportCredentials(opt *GrpcDialOptions) (credentials.TransportCredentials, error) {
+	if opt.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+
+	if !opt.tlsEnabled() {
+		return nil, nil
+	}
+
+	tlsConfig, err := newTLSConfig(opt.TLSCAFile, opt.TLSCertFile, opt.TLSKeyFile, opt.TLSServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// newTLSConfig creates a client TLS config trusting the CA bundle in caFile, or the system roots when empty,
+// and presenting the certificate in certFile and keyFile for mutual TLS when given.
+func newTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("failed to add CA bundle to pool")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	switch {
+	case certFile != "" && keyFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case certFile != "" || keyFile != "":
+		return nil, errors.New("both client certificate and key are required for mTLS")
+	}
+
+	return tlsConfig, nil
+}
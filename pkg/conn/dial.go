@@ -2,12 +2,17 @@ package conn
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 
 	"strings"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
 )
 
 // GrpcDialOptions contains options for dialing a grpc service
@@ -16,45 +21,166 @@ type GrpcDialOptions struct {
 	Address     string
 	DialOptions []grpc.DialOption
 	K8Service   bool
+
+	// Scheme selects a resolver registered with RegisterResolver, it takes precedence over K8Service
+	Scheme string
+	// LoadBalancing is the load balancing policy for the target, defaults to RoundRobin
+	LoadBalancing string
+
+	// Insecure disables transport security; it cannot be combined with TLS settings
+	Insecure bool
+	// TLSEnabled dials with TLS using the system root CAs when no CA bundle is given
+	TLSEnabled    bool
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSServerName string
+
+	// Token is a static bearer token attached to every RPC.
+	// Tokens require a secure connection only when TLS is configured here, not by credentials in DialOptions.
+	Token string
+	// TokenSource mints bearer tokens on demand, it takes precedence over Token
+	TokenSource TokenSource
+
+	// Block makes the dial wait until the connection is ready or DialTimeout elapses
+	Block       bool
+	DialTimeout time.Duration
+	// HealthCheck checks the target's grpc.health.v1 service after a blocking dial
+	HealthCheck        bool
+	HealthCheckService string
+
+	// DisableWaitForReady lets unary calls fail fast instead of waiting for the connection to be ready
+	DisableWaitForReady bool
+	// WaitForReadyTimeout bounds how long unary calls without a deadline wait for the connection to be ready
+	WaitForReadyTimeout time.Duration
+
+	// Logger logs connectivity state transitions of the connection when set
+	Logger grpclog.LoggerV2
+	// OnStateChange is called with every connectivity state transition of the connection
+	OnStateChange func(from, to connectivity.State)
+}
+
+func (opt *GrpcDialOptions) tlsEnabled() bool {
+	return opt.TLSEnabled || opt.TLSCAFile != "" || opt.TLSCertFile != "" || opt.TLSKeyFile != "" || opt.TLSServerName != ""
 }
 
 // DialGrpcService dials to a grpc service
 func DialGrpcService(ctx context.Context, opt *GrpcDialOptions) (*grpc.ClientConn, error) {
+	// Options should not be nil
+	if opt == nil {
+		return nil, errors.New("nil dial options not allowed")
+	}
+
+	if opt.Insecure && opt.tlsEnabled() {
+		return nil, errors.New("insecure dial cannot be combined with tls options")
+	}
+
+	lbPolicy := opt.LoadBalancing
+	if lbPolicy == "" {
+		lbPolicy = RoundRobin
+	}
+
 	var (
 		dopts = []grpc.DialOption{
-			grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [ { "round_robin": {} } ] }`),
+			grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [ { "%s": {} } ] }`, lbPolicy)),
 			// Load balancer scheme
 			grpc.WithDisableServiceConfig(),
-			// Other interceptors
-			grpc.WithUnaryInterceptor(
-				grpc_middleware.ChainUnaryClient(
-					waitForReadyInterceptor,
-				),
-			),
 		}
 	)
 
+	if !opt.DisableWaitForReady {
+		// Other interceptors
+		dopts = append(dopts, grpc.WithUnaryInterceptor(
+			grpc_middleware.ChainUnaryClient(
+				waitForReadyInterceptor(opt.WaitForReadyTimeout),
+			),
+		))
+	}
+
+	creds, err := transportCredentials(opt)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		dopts = append(dopts, grpc.WithTransportCredentials(creds))
+	}
+
+	tokenSource := opt.TokenSource
+	if tokenSource == nil && opt.Token != "" {
+		tokenSource = StaticToken(opt.Token)
+	}
+	if tokenSource != nil {
+		// Credentials passed in DialOptions may be insecure so only require TLS when it is configured here
+		dopts = append(dopts, grpc.WithPerRPCCredentials(NewTokenCredentials(tokenSource, opt.tlsEnabled())))
+	}
+
 	dopts = append(dopts, opt.DialOptions...)
 
-	// Address for dialing the kubernetes service
-	if opt.K8Service {
-		opt.Address = strings.TrimSuffix(opt.Address, "dns:///")
-		opt.Address = "dns:///" + opt.Address
-	} else {
-		opt.Address = strings.TrimSuffix(opt.Address, "passthrough:///")
-		opt.Address = "passthrough:///" + opt.Address
+	if !opt.Block {
+		cc, err := grpc.DialContext(ctx, dialTarget(opt), dopts...)
+		if err != nil {
+			return nil, err
+		}
+		opt.watchState(cc)
+		return cc, nil
+	}
+
+	if opt.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.DialTimeout)
+		defer cancel()
+	}
+
+	dopts = append(dopts, grpc.WithBlock(), grpc.WithReturnConnectionError())
+
+	cc, err := grpc.DialContext(ctx, dialTarget(opt), dopts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s service: %v", opt.ServiceName, err)
 	}
 
-	return grpc.DialContext(ctx, opt.Address, dopts...)
+	if opt.HealthCheck {
+		err = CheckHealth(ctx, cc, opt.HealthCheckService)
+		if err != nil {
+			cc.Close()
+			return nil, fmt.Errorf("%s service is not healthy: %v", opt.ServiceName, err)
+		}
+	}
+
+	opt.watchState(cc)
+
+	return cc, nil
 }
 
-func waitForReadyInterceptor(
-	ctx context.Context,
-	method string,
-	req, reply interface{},
-	cc *grpc.ClientConn,
-	invoker grpc.UnaryInvoker,
-	opts ...grpc.CallOption,
-) error {
-	return invoker(ctx, method, req, reply, cc, append(opts, grpc.WaitForReady(true))...)
+// dialTarget returns the address prefixed with the scheme of the resolver to use
+func dialTarget(opt *GrpcDialOptions) string {
+	var scheme string
+	switch {
+	case opt.Scheme != "":
+		scheme = opt.Scheme
+	case opt.K8Service:
+		// Address for dialing the kubernetes service
+		scheme = "dns"
+	default:
+		scheme = "passthrough"
+	}
+	return scheme + ":///" + strings.TrimPrefix(opt.Address, scheme+":///")
+}
+
+// waitForReadyInterceptor makes unary calls wait for the connection to be ready, bounding calls without a deadline by timeout
+func waitForReadyInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, append(opts, grpc.WaitForReady(true))...)
+	}
 }
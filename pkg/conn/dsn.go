@@ -0,0 +1,211 @@
+package conn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// dsn returns the database/sql driver name and data source name for the dialect.
+//
+// Addresses starting with / are treated as unix sockets for MySQL and PostgreSQL.
+// ReadTimeout, WriteTimeout and Collation only apply to MySQL while TLS does not apply to SQLite.
+// Params are appended as driver parameters and override the ones derived from other fields.
+func (opt *DbOptions) dsn() (string, string, error) {
+	switch opt.dialect() {
+	case DialectMySQL:
+		dsn, err := opt.mysqlDSN()
+		return "mysql", dsn, err
+	case DialectPostgres:
+		dsn, err := opt.postgresDSN()
+		return "pgx", dsn, err
+	case DialectSQLite:
+		return "sqlite3", opt.sqliteDSN(), nil
+	default:
+		return "", "", fmt.Errorf("unsupported database dialect %q", opt.Dialect)
+	}
+}
+
+func (opt *DbOptions) mysqlDSN() (string, error) {
+	cfg := mysqldriver.NewConfig()
+	cfg.User = opt.User
+	cfg.Passwd = opt.Password
+	cfg.Net = "tcp"
+	cfg.Addr = opt.Address
+	cfg.DBName = opt.Schema
+	cfg.Timeout = opt.ConnectTimeout
+	cfg.ReadTimeout = opt.ReadTimeout
+	cfg.WriteTimeout = opt.WriteTimeout
+	// add MySQL driver specific parameter to parse date/time
+	cfg.ParseTime = true
+	cfg.Params = map[string]string{"charset": "utf8mb4"}
+
+	if strings.HasPrefix(opt.Address, "/") {
+		cfg.Net = "unix"
+	}
+
+	if opt.Collation != "" {
+		cfg.Collation = opt.Collation
+	}
+
+	if opt.TimeZone != "" {
+		loc, err := time.LoadLocation(opt.TimeZone)
+		if err != nil {
+			return "", fmt.Errorf("failed to load time zone: %v", err)
+		}
+		cfg.Loc = loc
+		cfg.Params["time_zone"] = "'" + opt.TimeZone + "'"
+	}
+
+	if opt.TLS != nil && opt.TLS.Mode != "" && opt.TLS.Mode != TLSModeDisable {
+		tlsConfig, err := opt.TLS.config(opt.Address)
+		if err != nil {
+			return "", err
+		}
+		cfg.TLSConfig = "gomicro_" + opt.Name + "_" + opt.Address
+		err = mysqldriver.RegisterTLSConfig(cfg.TLSConfig, tlsConfig)
+		if err != nil {
+			return "", fmt.Errorf("failed to register mysql tls config: %v", err)
+		}
+	}
+
+	for key, value := range opt.Params {
+		cfg.Params[key] = value
+	}
+
+	return cfg.FormatDSN(), nil
+}
+
+func (opt *DbOptions) postgresDSN() (string, error) {
+	params := url.Values{}
+	params.Set("sslmode", TLSModeDisable)
+
+	dsn := &url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(opt.User, opt.Password),
+		Host:   opt.Address,
+		Path:   "/" + opt.Schema,
+	}
+
+	if strings.HasPrefix(opt.Address, "/") {
+		dsn.Host = ""
+		params.Set("host", opt.Address)
+	}
+
+	if opt.ConnectTimeout > 0 {
+		params.Set("connect_timeout", strconv.Itoa(int(opt.ConnectTimeout.Seconds())))
+	}
+
+	if opt.TimeZone != "" {
+		params.Set("timezone", opt.TimeZone)
+	}
+
+	if opt.TLS != nil && opt.TLS.Mode != "" {
+		switch opt.TLS.Mode {
+		case TLSModeDisable, TLSModeRequire, TLSModeVerifyCA, TLSModeVerifyFull:
+		default:
+			return "", fmt.Errorf("unsupported tls mode %q", opt.TLS.Mode)
+		}
+		params.Set("sslmode", opt.TLS.Mode)
+		if opt.TLS.CAFile != "" {
+			params.Set("sslrootcert", opt.TLS.CAFile)
+		}
+		if opt.TLS.CertFile != "" {
+			params.Set("sslcert", opt.TLS.CertFile)
+		}
+		if opt.TLS.KeyFile != "" {
+			params.Set("sslkey", opt.TLS.KeyFile)
+		}
+	}
+
+	for key, value := range opt.Params {
+		params.Set(key, value)
+	}
+
+	dsn.RawQuery = params.Encode()
+
+	return dsn.String(), nil
+}
+
+func (opt *DbOptions) sqliteDSN() string {
+	params := url.Values{}
+	for key, value := range opt.Params {
+		params.Set(key, value)
+	}
+
+	// An empty address or :memory: opens a shared in-memory database, useful for tests
+	if opt.Address == "" || opt.Address == ":memory:" {
+		name := opt.Name
+		if name == "" {
+			name = "memdb"
+		}
+		params.Set("mode", "memory")
+		params.Set("cache", "shared")
+		return fmt.Sprintf("file:%s?%s", url.PathEscape(name), params.Encode())
+	}
+
+	if len(params) == 0 {
+		return opt.Address
+	}
+
+	return fmt.Sprintf("file:%s?%s", opt.Address, params.Encode())
+}
+
+// config creates a tls config for the mode
+func (opt *DbTLSOptions) config(address string) (*tls.Config, error) {
+	serverName := opt.ServerName
+	if serverName == "" {
+		serverName = strings.Split(address, ":")[0]
+	}
+
+	tlsConfig, err := newTLSConfig(opt.CAFile, opt.CertFile, opt.KeyFile, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opt.Mode {
+	case TLSModeRequire:
+		tlsConfig.InsecureSkipVerify = true
+	case TLSModeVerifyCA:
+		// Verify the certificate chain but not the host name
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyCertificateChain(tlsConfig.RootCAs)
+	case TLSModeVerifyFull:
+	default:
+		return nil, fmt.Errorf("unsupported tls mode %q", opt.Mode)
+	}
+
+	return tlsConfig, nil
+}
+
+func verifyCertificateChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server presented no certificates")
+		}
+
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, rawCert := range rawCerts {
+			cert, err := x509.ParseCertificate(rawCert)
+			if err != nil {
+				return fmt.Errorf("failed to parse server certificate: %v", err)
+			}
+			certs = append(certs, cert)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+}
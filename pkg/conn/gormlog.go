@@ -0,0 +1,150 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// GormLoggerOptions contains options for the gorm query logger
+type GormLoggerOptions struct {
+	// Level is the gorm log level, defaults to logger.Warn which logs errors and slow queries
+	Level logger.LogLevel
+	// SlowThreshold is the duration above which queries are logged as slow, defaults to 200 milliseconds
+	SlowThreshold time.Duration
+	// Redact replaces literal values in logged statements with placeholders
+	Redact bool
+	// IgnoreRecordNotFound skips logging gorm.ErrRecordNotFound errors
+	IgnoreRecordNotFound bool
+	// ContextKeys are incoming gRPC metadata keys logged with every statement,
+	// defaults to x-request-id, x-trace-id and traceparent
+	ContextKeys []string
+}
+
+type gormLogger struct {
+	log grpclog.LoggerV2
+	opt GormLoggerOptions
+}
+
+// NewGormLogger creates a gorm logger that writes through a grpc logger such as the service zerolog or zap logger
+func NewGormLogger(log grpclog.LoggerV2, opt *GormLoggerOptions) logger.Interface {
+	gl := &gormLogger{log: log}
+	if opt != nil {
+		gl.opt = *opt
+	}
+	if gl.opt.Level == 0 {
+		gl.opt.Level = logger.Warn
+	}
+	if gl.opt.SlowThreshold <= 0 {
+		gl.opt.SlowThreshold = 200 * time.Millisecond
+	}
+	if gl.opt.ContextKeys == nil {
+		gl.opt.ContextKeys = []string{"x-request-id", "x-trace-id", "traceparent"}
+	}
+	return gl
+}
+
+func (gl *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	newLogger := *gl
+	newLogger.opt.Level = level
+	return &newLogger
+}
+
+func (gl *gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if gl.opt.Level >= logger.Info {
+		gl.log.Infof("%s%s", fmt.Sprintf(msg, args...), gl.contextFields(ctx))
+	}
+}
+
+func (gl *gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if gl.opt.Level >= logger.Warn {
+		gl.log.Warningf("%s%s", fmt.Sprintf(msg, args...), gl.contextFields(ctx))
+	}
+}
+
+func (gl *gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if gl.opt.Level >= logger.Error {
+		gl.log.Errorf("%s%s", fmt.Sprintf(msg, args...), gl.contextFields(ctx))
+	}
+}
+
+func (gl *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if gl.opt.Level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+
+	switch {
+	case err != nil && gl.opt.Level >= logger.Error &&
+		!(gl.opt.IgnoreRecordNotFound && errors.Is(err, gorm.ErrRecordNotFound)):
+		sql, rows := fc()
+		gl.log.Errorf("(GORM) query failed: %v [sql=%s] [rows=%d] [duration=%s]%s",
+			err, gl.statement(sql), rows, elapsed, gl.contextFields(ctx))
+	case elapsed > gl.opt.SlowThreshold && gl.opt.Level >= logger.Warn:
+		sql, rows := fc()
+		gl.log.Warningf("(GORM) slow query [fingerprint=%s] [sql=%s] [rows=%d] [duration=%s] [threshold=%s]%s",
+			Fingerprint(sql), gl.statement(sql), rows, elapsed, gl.opt.SlowThreshold, gl.contextFields(ctx))
+	case gl.opt.Level >= logger.Info:
+		sql, rows := fc()
+		gl.log.Infof("(GORM) [sql=%s] [rows=%d] [duration=%s]%s",
+			gl.statement(sql), rows, elapsed, gl.contextFields(ctx))
+	}
+}
+
+func (gl *gormLogger) statement(sql string) string {
+	if gl.opt.Redact {
+		return redactSQL(sql)
+	}
+	return sql
+}
+
+// contextFields formats request and trace identifiers found in the incoming metadata of ctx
+func (gl *gormLogger) contextFields(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	var sb strings.Builder
+	for _, key := range gl.opt.ContextKeys {
+		if values := md.Get(key); len(values) > 0 {
+			fmt.Fprintf(&sb, " [%s=%s]", key, values[0])
+		}
+	}
+	return sb.String()
+}
+
+var (
+	sqlLiteralRe    = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"|\b\d+(?:\.\d+)?\b`)
+	sqlWhitespaceRe = regexp.MustCompile(`\s+`)
+	sqlInListRe     = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+)
+
+// redactSQL replaces string and number literals in the statement with placeholders
+func redactSQL(sql string) string {
+	return sqlLiteralRe.ReplaceAllString(sql, "?")
+}
+
+// Fingerprint returns a short hash identifying the statement regardless of its literal values
+func Fingerprint(sql string) string {
+	normalized := redactSQL(sql)
+	normalized = sqlInListRe.ReplaceAllString(normalized, "(?)")
+	normalized = strings.ToLower(strings.TrimSpace(sqlWhitespaceRe.ReplaceAllString(normalized, " ")))
+
+	h := fnv.New64a()
+	h.Write([]byte(normalized))
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,108 @@
+package conn
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+)
+
+// DbRetrySettings contains options for retrying the initial database connection with exponential backoff
+type DbRetrySettings struct {
+	// Timeout is the deadline for the database to become reachable, defaults to 1 minute
+	Timeout time.Duration
+	// InitialInterval is the wait before the first retry, defaults to 500 milliseconds
+	InitialInterval time.Duration
+	// MaxInterval caps the wait between retries, defaults to 10 seconds
+	MaxInterval time.Duration
+}
+
+// ping pings the database until it succeeds or the retry timeout elapses
+func (retry *DbRetrySettings) ping(sqlDB *sql.DB, opt *DbOptions) error {
+	timeout, interval, maxInterval := retry.Timeout, retry.InitialInterval, retry.MaxInterval
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	if maxInterval <= 0 {
+		maxInterval = 10 * time.Second
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := sqlDB.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("database not reachable after %d attempts: %v", attempt, err)
+		}
+
+		if opt.Logger != nil {
+			opt.Logger.Warningf("database %s not reachable (attempt %d), retrying in %s: %v", opt.Name, attempt, interval, err)
+		}
+
+		time.Sleep(interval)
+
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// Ping verifies that the database is reachable, it can be used as a service readiness check
+func Ping(ctx context.Context, db *gorm.DB) error {
+	if db == nil {
+		return errors.New("nil database")
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// MonitorPool logs a warning every interval in which queries had to wait for a connection from the pool.
+//
+// It blocks until ctx is done and should be run in its own goroutine.
+func MonitorPool(ctx context.Context, name string, sqlDB *sql.DB, interval time.Duration, logger grpclog.LoggerV2) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := sqlDB.Stats()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats := sqlDB.Stats()
+
+		waits := stats.WaitCount - last.WaitCount
+		if waits > 0 {
+			logger.Warningf(
+				"database %s pool exhausted: %d queries waited %s for a connection [in_use=%d] [idle=%d] [max_open=%d]",
+				name, waits, stats.WaitDuration-last.WaitDuration, stats.InUse, stats.Idle, stats.MaxOpenConnections,
+			)
+		}
+
+		last = stats
+	}
+}
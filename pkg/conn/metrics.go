@@ -0,0 +1,120 @@
+package conn
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gorm_query_duration_seconds",
+		Help:    "Latency of gorm queries by database, operation and table.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"db_name", "operation", "table"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gorm_query_errors_total",
+		Help: "Number of failed gorm queries by database, operation and table.",
+	}, []string{"db_name", "operation", "table"})
+)
+
+// registerDBStats exports the connection pool statistics of sqlDB labeled by the database name.
+//
+// A pool opened with the name of a registered pool replaces it, so that reopened pools export their own statistics.
+func registerDBStats(registerer prometheus.Registerer, name string, sqlDB *sql.DB) error {
+	collector := collectors.NewDBStatsCollector(sqlDB, name)
+
+	err := registerer.Register(collector)
+	if are := (prometheus.AlreadyRegisteredError{}); errors.As(err, &are) {
+		registerer.Unregister(are.ExistingCollector)
+		err = registerer.Register(collector)
+	}
+	return err
+}
+
+// register registers c or returns the collector already registered in its place
+func register(registerer prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	err := registerer.Register(c)
+	if are := (prometheus.AlreadyRegisteredError{}); errors.As(err, &are) {
+		return are.ExistingCollector, nil
+	}
+	return c, err
+}
+
+// GormMetrics is a gorm plugin that records query latency and errors by operation and table
+type GormMetrics struct {
+	DBName     string
+	Registerer prometheus.Registerer
+}
+
+// Name returns the name of the plugin
+func (gm *GormMetrics) Name() string {
+	return "gomicro:metrics"
+}
+
+const metricsStartKey = "gomicro:metrics_start"
+
+// Initialize registers the metrics and the gorm callbacks that record them
+func (gm *GormMetrics) Initialize(db *gorm.DB) error {
+	registerer := gm.Registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	durations, err := register(registerer, queryDuration)
+	if err != nil {
+		return err
+	}
+	errs, err := register(registerer, queryErrors)
+	if err != nil {
+		return err
+	}
+
+	before := func(db *gorm.DB) {
+		db.InstanceSet(metricsStartKey, time.Now())
+	}
+
+	after := func(operation string) func(*gorm.DB) {
+		return func(db *gorm.DB) {
+			start, ok := db.InstanceGet(metricsStartKey)
+			if !ok {
+				return
+			}
+			labels := prometheus.Labels{"db_name": gm.DBName, "operation": operation, "table": db.Statement.Table}
+
+			durations.(*prometheus.HistogramVec).With(labels).Observe(time.Since(start.(time.Time)).Seconds())
+
+			if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+				errs.(*prometheus.CounterVec).With(labels).Inc()
+			}
+		}
+	}
+
+	callback := db.Callback()
+
+	for _, err := range []error{
+		callback.Create().Before("gorm:create").Register("gomicro:metrics_before_create", before),
+		callback.Create().After("gorm:create").Register("gomicro:metrics_after_create", after("create")),
+		callback.Query().Before("gorm:query").Register("gomicro:metrics_before_query", before),
+		callback.Query().After("gorm:query").Register("gomicro:metrics_after_query", after("query")),
+		callback.Update().Before("gorm:update").Register("gomicro:metrics_before_update", before),
+		callback.Update().After("gorm:update").Register("gomicro:metrics_after_update", after("update")),
+		callback.Delete().Before("gorm:delete").Register("gomicro:metrics_before_delete", before),
+		callback.Delete().After("gorm:delete").Register("gomicro:metrics_after_delete", after("delete")),
+		callback.Row().Before("gorm:row").Register("gomicro:metrics_before_row", before),
+		callback.Row().After("gorm:row").Register("gomicro:metrics_after_row", after("row")),
+		callback.Raw().Before("gorm:raw").Register("gomicro:metrics_before_raw", before),
+		callback.Raw().After("gorm:raw").Register("gomicro:metrics_after_raw", after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
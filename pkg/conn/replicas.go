@@ -0,0 +1,164 @@
+package conn
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+type primaryCtxKey struct{}
+
+// WithPrimary returns a context that routes all queries using it to the primary database.
+//
+// It is useful for reading your own writes without waiting for replication.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, true)
+}
+
+// Primary forces the query to be executed on the primary database
+func Primary(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Write)
+}
+
+// useReplicas opens the read replicas and registers a resolver that routes reads to them.
+//
+// Writes and transactions always go to the primary. Replicas opened before an error are closed.
+func useReplicas(db *gorm.DB, primary *sql.DB, opt *DbOptions) (err error) {
+	interval := opt.ReplicaHealthInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	policy := &replicaPolicy{
+		primary:  primary,
+		replicas: make(map[gorm.ConnPool]*replicaState, len(opt.ReplicaAddresses)),
+		interval: interval,
+	}
+
+	dialectors := make([]gorm.Dialector, 0, len(opt.ReplicaAddresses))
+
+	defer func() {
+		if err != nil {
+			for _, state := range policy.replicas {
+				state.db.Close()
+			}
+		}
+	}()
+
+	for i, address := range opt.ReplicaAddresses {
+		replicaOpt := *opt
+		replicaOpt.Name = fmt.Sprintf("%s_replica_%d", opt.Name, i+1)
+		replicaOpt.Address = address
+		replicaOpt.ReplicaAddresses = nil
+
+		sqlDB, err := open(&replicaOpt)
+		if err != nil {
+			return fmt.Errorf("failed to open replica [address=%s]: %v", address, err)
+		}
+
+		if err := replicaOpt.registerDBStats(sqlDB); err != nil {
+			sqlDB.Close()
+			return err
+		}
+
+		policy.replicas[sqlDB] = &replicaState{db: sqlDB, healthy: 1}
+		dialectors = append(dialectors, replicaOpt.gormDialector(sqlDB))
+	}
+
+	// Route queries with a primary context to the primary
+	usePrimary := func(db *gorm.DB) {
+		if primary, _ := db.Statement.Context.Value(primaryCtxKey{}).(bool); primary {
+			dbresolver.Write.ModifyStatement(db.Statement)
+		}
+	}
+
+	err = db.Callback().Query().Before("*").Register("gomicro:primary_context", usePrimary)
+	if err == nil {
+		err = db.Callback().Row().Before("*").Register("gomicro:primary_context", usePrimary)
+	}
+	if err == nil {
+		err = db.Callback().Raw().Before("*").Register("gomicro:primary_context", usePrimary)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to register primary context callback: %v", err)
+	}
+
+	err = db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   policy,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to register replicas resolver: %v", err)
+	}
+
+	return nil
+}
+
+type replicaState struct {
+	db        *sql.DB
+	healthy   int32
+	checking  int32
+	checkedAt int64
+}
+
+// replicaPolicy picks replicas in round-robin order skipping unhealthy ones.
+//
+// Replica health is checked lazily in the background at most once every interval.
+// When no replica is healthy queries are sent to the primary.
+type replicaPolicy struct {
+	primary  gorm.ConnPool
+	replicas map[gorm.ConnPool]*replicaState
+	interval time.Duration
+	next     uint32
+}
+
+func (p *replicaPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	start := atomic.AddUint32(&p.next, 1)
+
+	for i := range connPools {
+		connPool := connPools[(int(start)+i)%len(connPools)]
+
+		state, ok := p.replicas[connPool]
+		if !ok {
+			return connPool
+		}
+
+		p.checkHealth(state)
+
+		if atomic.LoadInt32(&state.healthy) == 1 {
+			return connPool
+		}
+	}
+
+	return p.primary
+}
+
+func (p *replicaPolicy) checkHealth(state *replicaState) {
+	if time.Since(time.Unix(0, atomic.LoadInt64(&state.checkedAt))) < p.interval {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&state.checking, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&state.checking, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		if err := state.db.PingContext(ctx); err != nil {
+			atomic.StoreInt32(&state.healthy, 0)
+		} else {
+			atomic.StoreInt32(&state.healthy, 1)
+		}
+
+		atomic.StoreInt64(&state.checkedAt, time.Now().UnixNano())
+	}()
+}
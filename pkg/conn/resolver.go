@@ -0,0 +1,191 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Endpoint is a network address of a service instance
+type Endpoint struct {
+	Address string `json:"address" yaml:"address"`
+	Weight  uint32 `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+// Resolver discovers the endpoints of services.
+//
+// Service registry backends like Consul or etcd should implement this interface and
+// optionally Watcher if they can push updates.
+type Resolver interface {
+	// Resolve returns the current endpoints of the service
+	Resolve(ctx context.Context, service string) ([]Endpoint, error)
+}
+
+// Watcher is implemented by resolvers that push endpoint updates.
+//
+// Watch should block calling update with the latest endpoints whenever they change until ctx is done.
+// Transient errors should be reported with ReportWatchError while watching continues with the last endpoints.
+type Watcher interface {
+	Watch(ctx context.Context, service string, update func([]Endpoint)) error
+}
+
+type watchErrorKey struct{}
+
+// ReportWatchError reports a resolution error of a Watch call to the gRPC connection it resolves for
+func ReportWatchError(ctx context.Context, err error) {
+	if report, ok := ctx.Value(watchErrorKey{}).(func(error)); ok {
+		report(err)
+	}
+}
+
+// DefaultResolveInterval is how often endpoints are re-resolved for resolvers that don't implement Watcher
+const DefaultResolveInterval = 30 * time.Second
+
+// RegisterResolver registers r with gRPC under scheme so that targets dialed as scheme:///service are resolved by r.
+//
+// Resolvers that don't implement Watcher are polled every interval, or DefaultResolveInterval when interval is zero.
+// Like gRPC resolvers, registration should happen at initialization time and is not thread safe.
+func RegisterResolver(scheme string, r Resolver, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultResolveInterval
+	}
+	resolver.Register(&resolverBuilder{scheme: scheme, resolver: r, interval: interval})
+}
+
+type resolverBuilder struct {
+	scheme   string
+	resolver Resolver
+	interval time.Duration
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	service := strings.TrimPrefix(target.URL.Path, "/")
+	if service == "" {
+		service = target.URL.Opaque
+	}
+	if service == "" {
+		return nil, fmt.Errorf("missing service name in %s target", b.scheme)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	gr := &grpcResolver{
+		service:  service,
+		resolver: b.resolver,
+		interval: b.interval,
+		cc:       cc,
+		cancel:   cancel,
+		resolve:  make(chan struct{}, 1),
+	}
+
+	gr.wg.Add(1)
+	go gr.run(ctx)
+
+	return gr, nil
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return b.scheme
+}
+
+// grpcResolver adapts a Resolver to gRPC's resolver.Resolver
+type grpcResolver struct {
+	service  string
+	resolver Resolver
+	interval time.Duration
+	cc       resolver.ClientConn
+	cancel   context.CancelFunc
+	resolve  chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (gr *grpcResolver) run(ctx context.Context) {
+	defer gr.wg.Done()
+
+	if watcher, ok := gr.resolver.(Watcher); ok {
+		err := watcher.Watch(context.WithValue(ctx, watchErrorKey{}, gr.cc.ReportError), gr.service, gr.update)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			gr.cc.ReportError(err)
+		}
+		// Fall back to polling so that resolution does not stop
+	}
+
+	ticker := time.NewTicker(gr.interval)
+	defer ticker.Stop()
+
+	for {
+		endpoints, err := gr.resolver.Resolve(ctx, gr.service)
+		if err != nil {
+			gr.cc.ReportError(err)
+		} else {
+			gr.update(endpoints)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-gr.resolve:
+		}
+	}
+}
+
+func (gr *grpcResolver) update(endpoints []Endpoint) {
+	if len(endpoints) == 0 {
+		gr.cc.ReportError(fmt.Errorf("no endpoints found for service %s", gr.service))
+		return
+	}
+
+	addrs := make([]resolver.Address, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addrs = append(addrs, resolver.Address{
+			Addr:               endpoint.Address,
+			BalancerAttributes: attributes.New(weightKey{}, endpoint.weight()),
+		})
+	}
+
+	err := gr.cc.UpdateState(resolver.State{Addresses: addrs})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		gr.cc.ReportError(err)
+	}
+}
+
+func (gr *grpcResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case gr.resolve <- struct{}{}:
+	default:
+	}
+}
+
+func (gr *grpcResolver) Close() {
+	gr.cancel()
+	gr.wg.Wait()
+}
+
+func (endpoint Endpoint) weight() uint32 {
+	if endpoint.Weight == 0 {
+		return 1
+	}
+	return endpoint.Weight
+}
+
+// StaticResolver resolves services to a fixed list of endpoints
+type StaticResolver map[string][]Endpoint
+
+// Resolve returns the endpoints registered for the service
+func (sr StaticResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	endpoints, ok := sr[service]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found in static resolver", service)
+	}
+	return endpoints, nil
+}
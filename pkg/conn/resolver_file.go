@@ -0,0 +1,117 @@
+package conn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FileResolver resolves services from a JSON or YAML endpoints file.
+//
+// The file maps service names to endpoints, for example:
+//
+//	users:
+//	  - address: 10.0.0.1:9090
+//	    weight: 2
+//	  - address: 10.0.0.2:9090
+//
+// The file is reloaded whenever its modification time changes.
+type FileResolver struct {
+	path     string
+	interval time.Duration
+	mu       sync.Mutex
+	modTime  time.Time
+	services map[string][]Endpoint
+}
+
+// NewFileResolver creates a resolver that reads endpoints from path, checking for changes every interval
+func NewFileResolver(path string, interval time.Duration) *FileResolver {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &FileResolver{path: path, interval: interval}
+}
+
+// Resolve returns the endpoints in the file for the service
+func (fr *FileResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	services, err := fr.load()
+	if err != nil {
+		return nil, err
+	}
+	endpoints, ok := services[service]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found in %s", service, fr.path)
+	}
+	return endpoints, nil
+}
+
+// Watch calls update with the service endpoints each time the file changes.
+//
+// Errors such as reading a file that is being rewritten are reported with ReportWatchError and the last endpoints are
+// kept until the file can be resolved again.
+func (fr *FileResolver) Watch(ctx context.Context, service string, update func([]Endpoint)) error {
+	ticker := time.NewTicker(fr.interval)
+	defer ticker.Stop()
+
+	var last []Endpoint
+
+	for {
+		endpoints, err := fr.Resolve(ctx, service)
+		if err != nil {
+			ReportWatchError(ctx, err)
+		} else if !reflect.DeepEqual(endpoints, last) {
+			update(endpoints)
+			last = endpoints
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (fr *FileResolver) load() (map[string][]Endpoint, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	info, err := os.Stat(fr.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat endpoints file: %v", err)
+	}
+
+	if fr.services != nil && info.ModTime().Equal(fr.modTime) {
+		return fr.services, nil
+	}
+
+	bs, err := ioutil.ReadFile(fr.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read endpoints file: %v", err)
+	}
+
+	services := map[string][]Endpoint{}
+
+	switch strings.ToLower(filepath.Ext(fr.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bs, &services)
+	default:
+		err = json.Unmarshal(bs, &services)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse endpoints file %s: %v", fr.path, err)
+	}
+
+	fr.services, fr.modTime = services, info.ModTime()
+
+	return services, nil
+}
@@ -0,0 +1,49 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SRVResolver resolves services using DNS SRV records.
+//
+// The service name is looked up as _Service._Proto.name, with Service defaulting to grpc and Proto to tcp.
+type SRVResolver struct {
+	Service  string
+	Proto    string
+	Resolver *net.Resolver
+}
+
+// Resolve returns the targets of the SRV records for the service weighted by the record weight
+func (sr *SRVResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	srvService, proto := sr.Service, sr.Proto
+	if srvService == "" {
+		srvService = "grpc"
+	}
+	if proto == "" {
+		proto = "tcp"
+	}
+
+	netResolver := sr.Resolver
+	if netResolver == nil {
+		netResolver = net.DefaultResolver
+	}
+
+	_, records, err := netResolver.LookupSRV(ctx, srvService, proto, service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup SRV records for %s: %v", service, err)
+	}
+
+	endpoints := make([]Endpoint, 0, len(records))
+	for _, record := range records {
+		endpoints = append(endpoints, Endpoint{
+			Address: net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))),
+			Weight:  uint32(record.Weight),
+		})
+	}
+
+	return endpoints, nil
+}
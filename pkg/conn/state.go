@@ -0,0 +1,58 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// CheckHealth checks the health of service using the grpc.health.v1 protocol on the connection.
+//
+// An empty service checks the overall health of the server.
+func CheckHealth(ctx context.Context, cc *grpc.ClientConn, service string) error {
+	res, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return fmt.Errorf("health check failed: %v", err)
+	}
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health check returned status %s", res.GetStatus())
+	}
+	return nil
+}
+
+// WatchState calls fn with every connectivity state transition of the connection until it is closed or ctx is done
+func WatchState(ctx context.Context, cc *grpc.ClientConn, fn func(from, to connectivity.State)) {
+	state := cc.GetState()
+	for state != connectivity.Shutdown {
+		if !cc.WaitForStateChange(ctx, state) {
+			return
+		}
+		newState := cc.GetState()
+		fn(state, newState)
+		state = newState
+	}
+}
+
+// watchState starts watching the connection state when a logger or state change callback is set
+func (opt *GrpcDialOptions) watchState(cc *grpc.ClientConn) {
+	if opt.Logger == nil && opt.OnStateChange == nil {
+		return
+	}
+
+	go WatchState(context.Background(), cc, func(from, to connectivity.State) {
+		if opt.Logger != nil {
+			switch to {
+			case connectivity.TransientFailure:
+				opt.Logger.Warningf("%s service connection changed from %s to %s", opt.ServiceName, from, to)
+			default:
+				opt.Logger.Infof("%s service connection changed from %s to %s", opt.ServiceName, from, to)
+			}
+		}
+		if opt.OnStateChange != nil {
+			opt.OnStateChange(from, to)
+		}
+	})
+}
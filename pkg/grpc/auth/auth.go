@@ -8,6 +8,7 @@ import (
 
 	"github.com/golang-jwt/jwt"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
@@ -22,6 +23,10 @@ type API struct {
 	audience      string
 	adminsGroup   []string
 	superAdmins   []string
+	keyring       *Keyring
+	validation    ValidationOptions
+	denylist      Denylist
+	logger        grpclog.LoggerV2
 }
 
 // NewAPI creates a jwt authentication and authorization API using HS256 algorithm
@@ -46,9 +51,45 @@ func NewAPI(signingKey []byte, issuer, audience string) *API {
 		superAdmins:   []string{},
 	}
 
+	_ = api.SetValidation(nil)
+
+	return api
+}
+
+// NewAPIWithKeyring creates a jwt authentication and authorization API in keyring mode.
+//
+// Tokens are signed with the active key of the keyring, which sets the kid header, and verified with the key the kid
+// header refers to. A keyring without an active key can only verify tokens.
+func NewAPIWithKeyring(keyring *Keyring, issuer, audience string) *API {
+
+	// Validation
+	switch {
+	case keyring == nil:
+		panic("missing jwt keyring")
+	case issuer == "":
+		panic("missing jwt issuer")
+	case audience == "":
+		panic("missing jwt audience")
+	}
+
+	api := &API{
+		issuer:      issuer,
+		audience:    audience,
+		adminsGroup: []string{},
+		superAdmins: []string{},
+		keyring:     keyring,
+	}
+
+	_ = api.SetValidation(nil)
+
 	return api
 }
 
+// Keyring returns the keyring of the API or nil when it is not in keyring mode
+func (api *API) Keyring() *Keyring {
+	return api.keyring
+}
+
 // AuthorizeGroups checks whether the claims Group in the context metadata.MD Authorization JWT is a member the allowed groups set
 //
 // If it's a member, Authorization will succeed, otherwise it will fail with codes.PermissionDenied.
@@ -186,7 +227,7 @@ func (api *API) GetClaims(ctx context.Context) (*Claims, error) {
 //
 // It uses the reciever SigningKey during parsing.
 func (api *API) GetClaimsFromJwt(jwt string) (*Claims, error) {
-	claims, err := api.parseToken(jwt, api.signingKey)
+	claims, err := api.parseToken(context.Background(), jwt, api.signingKey)
 	if err != nil {
 		return nil, err
 	}
@@ -218,33 +259,40 @@ func (api *API) GetMetadataFromCtx(ctx context.Context) metadata.MD {
 //
 // If error is returned, its grpc.Code() will be returned to the user as well as the verbatim message.
 // Please make sure you use codes.Unauthenticated (lacking auth) and codes.PermissionDenied
+//
+// Refresh tokens and tokens revoked in the denylist set with SetDenylist are rejected.
 func (api *API) Authenticator(ctx context.Context) (context.Context, error) {
 	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
 	if err != nil {
 		return nil, err
 	}
 
-	claims, err := api.parseToken(token, api.signingKey)
-	if err != nil {
-		fmt.Println(err)
-		return nil, status.Errorf(codes.Unauthenticated, "session expired")
-	}
-
-	grpc_ctxtags.Extract(ctx).Set("auth.sub", userClaimFromToken(claims))
-
-	return context.WithValue(ctx, claimsKey, claims), nil
+	return api.authenticate(ctx, token, api.signingKey)
 }
 
-// AuthenticatorWithKey works like Authenticator but allow users to pass in custome key for decoding jwt data
+// AuthenticatorWithKey works like Authenticator but allow users to pass in custome key for decoding jwt data.
+//
+// Only HMAC signed tokens are verified with the key, also in keyring mode.
 func (api *API) AuthenticatorWithKey(ctx context.Context, signingKey []byte) (context.Context, error) {
 	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
 	if err != nil {
 		return nil, err
 	}
 
-	claims, err := api.parseToken(token, signingKey)
+	return api.authenticate(ctx, token, signingKey)
+}
+
+// authenticate validates the access token and returns a context with its claims
+func (api *API) authenticate(ctx context.Context, token string, signingKey []byte) (context.Context, error) {
+	claims, err := api.parseToken(ctx, token, signingKey)
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "session expired")
+		api.logAuthFailure(err)
+		return nil, err
+	}
+
+	if err := api.checkAccess(ctx, claims); err != nil {
+		api.logAuthFailure(err)
+		return nil, err
 	}
 
 	grpc_ctxtags.Extract(ctx).Set("auth.sub", userClaimFromToken(claims))
@@ -256,28 +304,49 @@ func userClaimFromToken(claims *Claims) *Payload {
 	return claims.Payload
 }
 
-// parses a jwt token and return claims or error if token is invalid
-func (api *API) parseToken(tokenString string, signingKey []byte) (claims *Claims, err error) {
+func (api *API) logAuthFailure(err error) {
+	if api.logger != nil {
+		api.logger.Infof("authentication failed: %v", err)
+	}
+}
+
+// parses a jwt token and return claims or a *TokenError if token is invalid
+func (api *API) parseToken(ctx context.Context, tokenString string, signingKey []byte) (claims *Claims, err error) {
 	// Handling any panic is good trust me!
 	defer func() {
 		if err2 := recover(); err2 != nil {
-			err = fmt.Errorf("%v", err2)
+			err = tokenError(ErrTokenMalformed, "%v", err2)
 		}
 	}()
 
-	token, err := jwt.ParseWithClaims(
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	token, err := parser.ParseWithClaims(
 		tokenString,
 		&Claims{},
 		func(token *jwt.Token) (interface{}, error) {
+			if err := api.checkAlgorithm(token); err != nil {
+				return nil, err
+			}
+			if api.keyring != nil && signingKey == nil {
+				return api.keyring.keyFunc(ctx, token)
+			}
+			// Shared keys only verify HMAC tokens, as keyring keys only verify tokens of their algorithm
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, tokenError(ErrTokenAlgorithm, "algorithm %s for shared key", token.Method.Alg())
+			}
 			return signingKey, nil
 		},
 	)
 	if err != nil {
-		return nil, status.Error(codes.Unauthenticated, err.Error())
+		return nil, parseError(err)
 	}
 	claims, ok := token.Claims.(*Claims)
 	if !ok || !token.Valid {
-		return nil, status.Error(codes.Unauthenticated, "JWT is not valid")
+		return nil, tokenError(ErrTokenMalformed, "unexpected claims")
+	}
+	if err := api.validateClaims(claims); err != nil {
+		return nil, err
 	}
 	return claims, nil
 }
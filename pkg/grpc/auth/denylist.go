@@ -0,0 +1,181 @@
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MemoryDenylist is a Denylist kept in memory, it suits single instance services and tests
+type MemoryDenylist struct {
+	mu       sync.Mutex
+	tokens   map[string]time.Time
+	subjects map[string]time.Time
+}
+
+// NewMemoryDenylist creates an empty in-memory denylist
+func NewMemoryDenylist() *MemoryDenylist {
+	return &MemoryDenylist{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]time.Time),
+	}
+}
+
+// RevokeToken revokes the token with id until expires
+func (d *MemoryDenylist) RevokeToken(ctx context.Context, id string, expires time.Time) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	if exp, ok := d.tokens[id]; ok && now.Before(exp) {
+		return false, nil
+	}
+
+	// Expired tokens are no longer accepted and need not be remembered
+	for tokenID, exp := range d.tokens {
+		if !now.Before(exp) {
+			delete(d.tokens, tokenID)
+		}
+	}
+
+	d.tokens[id] = expires
+
+	return true, nil
+}
+
+// RevokeSubject revokes the tokens of subject issued before the given time
+func (d *MemoryDenylist) RevokeSubject(ctx context.Context, subject string, before time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if before.After(d.subjects[subject]) {
+		d.subjects[subject] = before
+	}
+
+	return nil
+}
+
+// IsRevoked checks whether the token with id, subject and issue time is revoked
+func (d *MemoryDenylist) IsRevoked(ctx context.Context, id, subject string, issuedAt time.Time) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if exp, ok := d.tokens[id]; ok && id != "" && time.Now().Before(exp) {
+		return true, nil
+	}
+
+	before, ok := d.subjects[subject]
+
+	return ok && subject != "" && issuedAt.Before(before), nil
+}
+
+// RevokedToken is a token revoked until it expires
+type RevokedToken struct {
+	ID        string    `gorm:"primaryKey;type:varchar(64)"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// TableName is the table of revoked tokens
+func (*RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// RevokedSubject revokes the tokens of a subject issued before a time
+type RevokedSubject struct {
+	Subject       string    `gorm:"primaryKey;type:varchar(255)"`
+	RevokedBefore time.Time `gorm:"not null"`
+}
+
+// TableName is the table of revoked subjects
+func (*RevokedSubject) TableName() string {
+	return "revoked_subjects"
+}
+
+// GormDenylist is a Denylist stored in a database shared by service instances
+type GormDenylist struct {
+	db *gorm.DB
+}
+
+// NewGormDenylist creates a denylist stored in db, creating or updating its tables
+func NewGormDenylist(db *gorm.DB) (*GormDenylist, error) {
+	if db == nil {
+		return nil, errors.New("nil gorm db not allowed")
+	}
+
+	if err := db.AutoMigrate(&RevokedToken{}, &RevokedSubject{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate denylist tables: %v", err)
+	}
+
+	return &GormDenylist{db: db}, nil
+}
+
+// RevokeToken revokes the token with id until expires
+func (d *GormDenylist) RevokeToken(ctx context.Context, id string, expires time.Time) (bool, error) {
+	db := d.db.WithContext(ctx)
+
+	// Expired entries may be kept until Purge, replace them
+	err := db.Where("id = ? AND expires_at <= ?", id, time.Now().UTC()).Delete(&RevokedToken{}).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke token: %v", err)
+	}
+
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&RevokedToken{ID: id, ExpiresAt: expires.UTC()})
+	if res.Error != nil {
+		return false, fmt.Errorf("failed to revoke token: %v", res.Error)
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+// RevokeSubject revokes the tokens of subject issued before the given time
+func (d *GormDenylist) RevokeSubject(ctx context.Context, subject string, before time.Time) error {
+	err := d.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "subject"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before"}),
+	}).Create(&RevokedSubject{Subject: subject, RevokedBefore: before.UTC()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke subject: %v", err)
+	}
+	return nil
+}
+
+// IsRevoked checks whether the token with id, subject and issue time is revoked
+func (d *GormDenylist) IsRevoked(ctx context.Context, id, subject string, issuedAt time.Time) (bool, error) {
+	db := d.db.WithContext(ctx)
+
+	var count int64
+
+	if id != "" {
+		err := db.Model(&RevokedToken{}).Where("id = ? AND expires_at > ?", id, time.Now().UTC()).Count(&count).Error
+		if err != nil {
+			return false, fmt.Errorf("failed to check revoked token: %v", err)
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	if subject != "" {
+		err := db.Model(&RevokedSubject{}).Where("subject = ? AND revoked_before > ?", subject, issuedAt.UTC()).Count(&count).Error
+		if err != nil {
+			return false, fmt.Errorf("failed to check revoked subject: %v", err)
+		}
+	}
+
+	return count > 0, nil
+}
+
+// Purge deletes revoked tokens that have expired and returns how many were deleted
+func (d *GormDenylist) Purge(ctx context.Context) (int64, error) {
+	res := d.db.WithContext(ctx).Where("expires_at <= ?", time.Now().UTC()).Delete(&RevokedToken{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to purge revoked tokens: %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
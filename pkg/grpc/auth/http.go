@@ -0,0 +1,112 @@
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoToken is returned when a http request carries no token
+var ErrNoToken = status.Error(codes.Unauthenticated, "request unauthenticated with bearer")
+
+// HTTPOptions contains options for authenticating http requests
+type HTTPOptions struct {
+	// Cookie is the name of a cookie that carries the token when there is no Authorization header
+	Cookie string
+	// QueryParam is the name of a query parameter that carries the token when there is no Authorization header or cookie,
+	// such as for websocket or download links. Tokens in URLs end up in logs and browser history so keep them short lived.
+	QueryParam string
+	// Optional lets requests without a token through without claims, requests with an invalid token are still refused
+	Optional bool
+}
+
+// AuthenticateRequest validates the token of a http request with the same rules as Authenticator.
+//
+// The token is read from the Authorization bearer header, then from the cookie or query parameter in opt when set.
+// It returns a child context of the request context holding the claims.
+func (api *API) AuthenticateRequest(r *http.Request, opt *HTTPOptions) (context.Context, error) {
+	if opt == nil {
+		opt = &HTTPOptions{}
+	}
+
+	token, err := tokenFromRequest(r, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.authenticate(r.Context(), token, api.signingKey)
+}
+
+// HTTPMiddleware returns a middleware that authenticates requests with AuthenticateRequest.
+//
+// The claims are put in the request context so GetClaims, AuthorizeGroups and AuthorizeIds work as in gRPC handlers.
+// Wrap the handlers registered with AddEndpoint that need authentication; the runtime mux is authenticated by the
+// gRPC server already.
+func (api *API) HTTPMiddleware(opt *HTTPOptions) func(http.Handler) http.Handler {
+	if opt == nil {
+		opt = &HTTPOptions{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, err := api.AuthenticateRequest(r, opt)
+			switch {
+			case errors.Is(err, ErrNoToken) && opt.Optional:
+				next.ServeHTTP(w, r)
+			case err != nil:
+				writeAuthError(w, err)
+			default:
+				next.ServeHTTP(w, r.WithContext(ctx))
+			}
+		})
+	}
+}
+
+func tokenFromRequest(r *http.Request, opt *HTTPOptions) (string, error) {
+	if header := r.Header.Get(Header()); header != "" {
+		splits := strings.SplitN(header, " ", 2)
+		if len(splits) < 2 || !strings.EqualFold(splits[0], Scheme()) || splits[1] == "" {
+			return "", status.Error(codes.Unauthenticated, "bad authorization string")
+		}
+		return splits[1], nil
+	}
+
+	if opt.Cookie != "" {
+		if cookie, err := r.Cookie(opt.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value, nil
+		}
+	}
+
+	if opt.QueryParam != "" {
+		if token := r.URL.Query().Get(opt.QueryParam); token != "" {
+			return token, nil
+		}
+	}
+
+	return "", ErrNoToken
+}
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+
+	code := http.StatusInternalServerError
+	switch st.Code() {
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+		if errors.Is(err, ErrNoToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		}
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	}
+
+	http.Error(w, st.Message(), code)
+}
@@ -0,0 +1,60 @@
+package grpcauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	api := NewAPI(testSigningKey, "issuer", "audience")
+
+	token, err := api.GenToken(context.Background(), &Payload{ID: "1", Group: DefaultAdminGroup()}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := api.AuthorizeGroups(r.Context(), DefaultAdminGroup()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		opt      *HTTPOptions
+		setup    func(r *http.Request)
+		expected int
+	}{
+		{"header", nil, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, http.StatusOK},
+		{"bad scheme", nil, func(r *http.Request) { r.Header.Set("Authorization", "Basic "+token) }, http.StatusUnauthorized},
+		{"invalid token", nil, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token+"x") }, http.StatusUnauthorized},
+		{"missing", nil, func(r *http.Request) {}, http.StatusUnauthorized},
+		{"optional", &HTTPOptions{Optional: true}, func(r *http.Request) {}, http.StatusForbidden},
+		{"cookie", &HTTPOptions{Cookie: "session"}, func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: token})
+		}, http.StatusOK},
+		{"cookie not enabled", nil, func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: token})
+		}, http.StatusUnauthorized},
+		{"query", &HTTPOptions{QueryParam: "access_token"}, func(r *http.Request) {
+			r.URL.RawQuery = "access_token=" + token
+		}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/download", nil)
+			tt.setup(r)
+			w := httptest.NewRecorder()
+
+			api.HTTPMiddleware(tt.opt)(handler).ServeHTTP(w, r)
+
+			if w.Code != tt.expected {
+				t.Fatalf("expected status %d got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
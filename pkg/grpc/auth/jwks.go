@@ -0,0 +1,447 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc/grpclog"
+)
+
+// JWKSPath is the well known path for serving a JSON Web Key Set
+const JWKSPath = "/.well-known/jwks.json"
+
+// JWK is a public JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyring as a JSON Web Key Set
+func (kr *Keyring) JWKS() (*JWKS, error) {
+	keys := kr.Keys()
+
+	jwks := &JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwk, err := NewJWK(key)
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+
+	return jwks, nil
+}
+
+// JWKSHandler returns a http handler that serves the public keys of the keyring.
+//
+// Register it on the issuing service using AddEndpoint with JWKSPath.
+func (kr *Keyring) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jwks, err := kr.JWKS()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(jwks)
+	})
+}
+
+// JWKSHandler returns a http handler that serves the public keys of the API keyring, it responds with 404 status
+// when the API is not in keyring mode.
+func (api *API) JWKSHandler() http.Handler {
+	if api.keyring == nil {
+		return http.NotFoundHandler()
+	}
+	return api.keyring.JWKSHandler()
+}
+
+// NewJWK encodes the public key of key as a JWK
+func NewJWK(key *Key) (*JWK, error) {
+	jwk := &JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T for key %s", key.Public, key.ID)
+	}
+
+	return jwk, nil
+}
+
+// Key decodes the JWK into a verification key
+func (jwk *JWK) Key() (*Key, error) {
+	var (
+		pub crypto.PublicKey
+		err error
+	)
+
+	switch jwk.Kty {
+	case "RSA":
+		pub, err = jwk.rsaKey()
+	case "EC":
+		pub, err = jwk.ecKey()
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", jwk.Crv)
+		}
+		var x []byte
+		x, err = unb64(jwk.X)
+		if err == nil && len(x) != ed25519.PublicKeySize {
+			err = errors.New("invalid Ed25519 key size")
+		}
+		pub = ed25519.PublicKey(x)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s key %s: %v", jwk.Kty, jwk.Kid, err)
+	}
+
+	method, err := signingMethodFor(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	// RSA keys may use another RSA algorithm
+	if jwk.Alg != "" && jwk.Alg != method.Alg() {
+		alt := jwt.GetSigningMethod(jwk.Alg)
+		switch alt.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			if jwk.Kty != "RSA" {
+				alt = nil
+			}
+		default:
+			alt = nil
+		}
+		if alt == nil {
+			return nil, fmt.Errorf("algorithm %s does not match %s key %s", jwk.Alg, jwk.Kty, jwk.Kid)
+		}
+		method = alt
+	}
+
+	return &Key{ID: jwk.Kid, Method: method, Public: pub}, nil
+}
+
+func (jwk *JWK) rsaKey() (*rsa.PublicKey, error) {
+	n, err := unb64(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := unb64(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+func (jwk *JWK) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported EC curve %q", jwk.Crv)
+	}
+	x, err := unb64(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := unb64(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+	pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("point is not on curve")
+	}
+	return pub, nil
+}
+
+func b64(bs []byte) string {
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func unb64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// JWKSOptions contains options for verifying tokens with keys from a JSON Web Key Set
+type JWKSOptions struct {
+	// URL serves the key set, such as https://auth.example.com/.well-known/jwks.json
+	URL string
+	// File contains the key set, it is used when URL is empty
+	File string
+	// HTTPClient fetches the key set, defaults to a client with a 10 second timeout.
+	// Fetches with a client without a timeout are bounded to 10 seconds.
+	HTTPClient *http.Client
+	// RefreshInterval is how often the key set is refreshed, defaults to 1 hour
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits refreshes triggered by unknown key ids and failures, defaults to 1 minute
+	MinRefreshInterval time.Duration
+	// Logger logs fetch failures, no logging is done when nil
+	Logger grpclog.LoggerV2
+}
+
+// jwksFetchTimeout bounds fetches of the key set
+const jwksFetchTimeout = 10 * time.Second
+
+// jwksSource refreshes a keyring from a JSON Web Key Set
+type jwksSource struct {
+	opt         JWKSOptions
+	mu          sync.Mutex
+	refreshing  int32
+	lastAttempt time.Time
+	lastSuccess time.Time
+	inflight    *jwksFetch
+}
+
+// jwksFetch is a fetch of the key set shared by concurrent refreshes
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKSKeyring creates a verification keyring that is loaded from a JSON Web Key Set.
+//
+// The key set is refreshed periodically and when a token refers to an unknown key id, at most once per
+// MinRefreshInterval. When fetching fails the keys fetched last are used, and a failing initial fetch is logged
+// and retried on the next verification.
+func NewJWKSKeyring(ctx context.Context, opt *JWKSOptions) (*Keyring, error) {
+	switch {
+	case opt == nil:
+		return nil, errors.New("nil jwks options not allowed")
+	case opt.URL == "" && opt.File == "":
+		return nil, errors.New("missing jwks url or file")
+	}
+
+	src := &jwksSource{opt: *opt}
+	if src.opt.HTTPClient == nil {
+		src.opt.HTTPClient = &http.Client{Timeout: jwksFetchTimeout}
+	}
+	if src.opt.RefreshInterval <= 0 {
+		src.opt.RefreshInterval = time.Hour
+	}
+	if src.opt.MinRefreshInterval <= 0 {
+		src.opt.MinRefreshInterval = time.Minute
+	}
+
+	kr := NewKeyring()
+	kr.source = src
+
+	_ = src.refresh(ctx, kr, true)
+
+	return kr, nil
+}
+
+// NewAPIWithJWKS creates a jwt authentication and authorization API that verifies tokens with keys from a JSON Web Key Set
+func NewAPIWithJWKS(ctx context.Context, opt *JWKSOptions, issuer, audience string) (*API, error) {
+	kr, err := NewJWKSKeyring(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	return NewAPIWithKeyring(kr, issuer, audience), nil
+}
+
+// refreshAsync refreshes stale keys in the background so that verification is not delayed
+func (src *jwksSource) refreshAsync(kr *Keyring) {
+	src.mu.Lock()
+	stale := time.Since(src.lastSuccess) >= src.opt.RefreshInterval
+	src.mu.Unlock()
+
+	if !stale || !atomic.CompareAndSwapInt32(&src.refreshing, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&src.refreshing, 0)
+		_ = src.refresh(context.Background(), kr, false)
+	}()
+}
+
+// refresh fetches the key set when the keys are stale, or when forced and the last attempt is old enough.
+//
+// The fetch runs outside the lock and concurrent refreshes wait for the fetch in flight instead of starting another.
+// ctx only bounds the wait, the fetch is not cancelled with it.
+func (src *jwksSource) refresh(ctx context.Context, kr *Keyring, force bool) error {
+	src.mu.Lock()
+
+	call := src.inflight
+	if call == nil {
+		now := time.Now()
+		if now.Sub(src.lastAttempt) < src.opt.MinRefreshInterval || (!force && now.Sub(src.lastSuccess) < src.opt.RefreshInterval) {
+			src.mu.Unlock()
+			return nil
+		}
+
+		src.lastAttempt = now
+		call = &jwksFetch{done: make(chan struct{})}
+		src.inflight = call
+		src.mu.Unlock()
+
+		go src.run(kr, call)
+	} else {
+		src.mu.Unlock()
+	}
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run fetches the key set for call and replaces the keys of the keyring on success.
+//
+// The fetch is shared by every waiter so it is bounded by the fetch timeout rather than the context of one of them.
+func (src *jwksSource) run(kr *Keyring, call *jwksFetch) {
+	ctx := context.Background()
+	if src.opt.HTTPClient.Timeout <= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jwksFetchTimeout)
+		defer cancel()
+	}
+
+	keys, err := src.fetch(ctx)
+	if err != nil {
+		if src.opt.Logger != nil {
+			src.opt.Logger.Warningf("failed to refresh jwks, using %d cached keys: %v", len(kr.Keys()), err)
+		}
+	} else {
+		kr.replace(keys)
+	}
+
+	src.mu.Lock()
+	if err == nil {
+		src.lastSuccess = time.Now()
+	}
+	src.inflight = nil
+	src.mu.Unlock()
+
+	call.err = err
+	close(call.done)
+}
+
+func (src *jwksSource) fetch(ctx context.Context) ([]*Key, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if src.opt.URL != "" {
+		data, err = src.fetchURL(ctx)
+	} else {
+		data, err = ioutil.ReadFile(src.opt.File)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &JWKS{}
+	if err := json.Unmarshal(data, jwks); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal jwks: %v", err)
+	}
+
+	keys := make([]*Key, 0, len(jwks.Keys))
+	for i := range jwks.Keys {
+		jwk := &jwks.Keys[i]
+		if jwk.Kid == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.Key()
+		if err != nil {
+			// Skip keys of unsupported types
+			if src.opt.Logger != nil {
+				src.opt.Logger.Warningf("skipping jwks key %s: %v", jwk.Kid, err)
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no usable signing keys")
+	}
+
+	return keys, nil
+}
+
+func (src *jwksSource) fetchURL(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.opt.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := src.opt.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: unexpected status %s", res.Status)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
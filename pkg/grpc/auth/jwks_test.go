@@ -0,0 +1,308 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func generateKeys(t *testing.T) []crypto.Signer {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []crypto.Signer{rsaKey, ecKey, edKey}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	for _, signer := range generateKeys(t) {
+		key := &Key{Private: signer}
+		if err := NewKeyring().Add(key); err != nil {
+			t.Fatal(err)
+		}
+
+		jwk, err := NewJWK(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, err := json.Marshal(jwk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &JWK{}
+		if err := json.Unmarshal(bs, decoded); err != nil {
+			t.Fatal(err)
+		}
+		if jwk.Kid != key.ID || jwk.Alg != key.Method.Alg() || jwk.Use != "sig" {
+			t.Fatalf("unexpected jwk header %+v for key %s", jwk, key.ID)
+		}
+
+		got, err := decoded.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != key.ID || got.Method != key.Method || got.Private != nil {
+			t.Fatalf("unexpected decoded key %+v", got)
+		}
+		if !key.Public.(interface{ Equal(crypto.PublicKey) bool }).Equal(got.Public) {
+			t.Fatalf("%s public key changed in round trip", jwk.Kty)
+		}
+	}
+
+	for _, jwk := range []*JWK{
+		{Kty: "oct", Kid: "1"},
+		{Kty: "OKP", Crv: "X25519", Kid: "1"},
+		{Kty: "EC", Crv: "P-256", Kid: "1", X: "AAAA", Y: "AAAA"},
+	} {
+		if _, err := jwk.Key(); err == nil {
+			t.Fatalf("expected error decoding %+v", jwk)
+		}
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	kr := NewKeyring()
+	for _, signer := range generateKeys(t) {
+		if err := kr.Add(&Key{Private: signer}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	kr.JWKSHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	jwks := &JWKS{}
+	if err := json.Unmarshal(w.Body.Bytes(), jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 3 {
+		t.Fatalf("expected 3 keys got %d", len(jwks.Keys))
+	}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty == "RSA" && (jwk.N == "" || jwk.E == "") {
+			t.Fatal("missing RSA public key")
+		}
+	}
+
+	w = httptest.NewRecorder()
+	kr.JWKSHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, JWKSPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected %d got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	NewAPI(testSigningKey, "issuer", "audience").JWKSHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected %d got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	ctx := context.Background()
+	signers := generateKeys(t)
+
+	issuerKeys := NewKeyring()
+	issuer := NewAPIWithKeyring(issuerKeys, "issuer", "audience")
+
+	genToken := func(signer crypto.Signer) string {
+		key := &Key{Private: signer}
+		if _, ok := issuerKeys.Key(mustKeyID(t, signer)); !ok {
+			if err := issuerKeys.Add(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := issuerKeys.SetActive(mustKeyID(t, signer)); err != nil {
+			t.Fatal(err)
+		}
+		token, err := issuer.GenToken(ctx, &Payload{ID: "1"}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	known := genToken(signers[0])
+
+	var (
+		fetches int32
+		slow    = make(chan struct{})
+		blocked int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&blocked) == 1 {
+			<-slow
+		}
+		issuerKeys.JWKSHandler().ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	api, err := NewAPIWithJWKS(ctx, &JWKSOptions{URL: srv.URL, MinRefreshInterval: time.Hour}, "issuer", "audience")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.GetClaimsFromJwt(known); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rotated key is fetched once for concurrent tokens with the unknown key id
+	rotated := genToken(signers[1])
+	atomic.StoreInt32(&blocked, 1)
+	api.keyring.source.mu.Lock()
+	api.keyring.source.lastAttempt = time.Time{}
+	api.keyring.source.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := api.GetClaimsFromJwt(rotated); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Known key ids do not wait for the refresh in flight
+	done := make(chan error)
+	go func() {
+		_, err := api.GetClaimsFromJwt(known)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("known key id blocked on jwks refresh")
+	}
+
+	close(slow)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("expected 2 fetches got %d", n)
+	}
+
+	// Unknown key ids are refreshed at most once per MinRefreshInterval
+	if _, err := api.GetClaimsFromJwt(genToken(signers[2])); !errors.Is(err, ErrTokenSignature) {
+		t.Fatalf("expected signature error got %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("expected rate limited refresh, got %d fetches", n)
+	}
+}
+
+func TestJWKSRefreshCancelled(t *testing.T) {
+	signers := generateKeys(t)
+
+	issuerKeys := NewKeyring()
+	for _, signer := range signers[:2] {
+		if err := issuerKeys.Add(&Key{Private: signer}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := issuerKeys.SetActive(mustKeyID(t, signers[1])); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewAPIWithKeyring(issuerKeys, "issuer", "audience").GenToken(context.Background(), &Payload{ID: "1"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		fetches int32
+		slow    = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			// The initial key set has the old key only
+			_ = json.NewEncoder(w).Encode(&JWKS{Keys: []JWK{mustJWK(t, issuerKeys, signers[0])}})
+			return
+		}
+		<-slow
+		issuerKeys.JWKSHandler().ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	api, err := NewAPIWithJWKS(context.Background(), &JWKSOptions{URL: srv.URL, MinRefreshInterval: time.Hour}, "issuer", "audience")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.keyring.source.mu.Lock()
+	api.keyring.source.lastAttempt = time.Time{}
+	api.keyring.source.mu.Unlock()
+
+	// A request that gives up waiting does not abort the fetch for the rotated key
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := api.parseToken(ctx, rotated, nil); err == nil {
+		t.Fatal("expected error for cancelled refresh")
+	}
+	close(slow)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := api.GetClaimsFromJwt(rotated)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated key not fetched after cancelled request: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("expected 2 fetches got %d", n)
+	}
+}
+
+func mustJWK(t *testing.T, kr *Keyring, signer crypto.Signer) JWK {
+	t.Helper()
+	key, _ := kr.Key(mustKeyID(t, signer))
+	jwk, err := NewJWK(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *jwk
+}
+
+func mustKeyID(t *testing.T, signer crypto.Signer) string {
+	t.Helper()
+	id, err := KeyID(signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
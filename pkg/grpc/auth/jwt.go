@@ -2,6 +2,8 @@ package grpcauth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -22,7 +24,58 @@ type Payload struct {
 // Claims contains JWT claims information
 type Claims struct {
 	*Payload
+	// Type is TokenTypeRefresh for refresh tokens and empty for access tokens
+	Type string `json:"token_type,omitempty"`
 	jwt.StandardClaims
+	// Audiences contains every audience of a parsed token, Audience holds the first one
+	Audiences []string `json:"-"`
+}
+
+// audience is an aud claim which is either a string or an array of strings
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = nil
+		if single != "" {
+			*aud = audience{single}
+		}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("aud claim must be a string or an array of strings: %v", err)
+	}
+	*aud = multiple
+
+	return nil
+}
+
+// UnmarshalJSON decodes the claims accepting an aud claim with multiple audiences
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		*Payload
+		Type string `json:"token_type,omitempty"`
+		jwt.StandardClaims
+		Audience audience `json:"aud,omitempty"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*c = Claims{
+		Payload:        decoded.Payload,
+		Type:           decoded.Type,
+		StandardClaims: decoded.StandardClaims,
+		Audiences:      decoded.Audience,
+	}
+	if len(c.Audiences) > 0 {
+		c.Audience = c.Audiences[0]
+	}
+
+	return nil
 }
 
 func (api *API) genToken(ctx context.Context, payload *Payload, expires int64) (tokenStr string, err error) {
@@ -32,21 +85,23 @@ func (api *API) genToken(ctx context.Context, payload *Payload, expires int64) (
 		}
 	}()
 
-	token := jwt.NewWithClaims(api.signingMethod, Claims{
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	return api.sign(Claims{
 		Payload: payload,
 		StandardClaims: jwt.StandardClaims{
 			Audience:  api.audience,
 			ExpiresAt: expires,
+			Id:        id,
 			IssuedAt:  time.Now().Unix(),
 			Issuer:    api.issuer,
 			NotBefore: 0,
 			Subject:   "",
 		},
-	})
-
-	token.Header["kid"] = payload.ProjectID
-
-	return token.SignedString(api.signingKey)
+	}, api.signingKey)
 }
 
 func (api *API) genTokenV2(ctx context.Context, claims *Claims, expires int64, signingKey []byte) (tokenStr string, err error) {
@@ -56,11 +111,54 @@ func (api *API) genTokenV2(ctx context.Context, claims *Claims, expires int64, s
 		}
 	}()
 
-	token := jwt.NewWithClaims(api.signingMethod, *claims)
+	// Fill registered claims the API validates
+	c := *claims
+	if expires > 0 {
+		c.ExpiresAt = expires
+	}
+	if c.IssuedAt == 0 {
+		c.IssuedAt = time.Now().Unix()
+	}
+	if c.Id == "" {
+		c.Id, err = newTokenID()
+		if err != nil {
+			return "", err
+		}
+	}
+	if c.Issuer == "" {
+		c.Issuer = api.issuer
+	}
+	if c.Audience == "" {
+		c.Audience = api.audience
+	}
+
+	return api.sign(c, signingKey)
+}
+
+// sign signs the claims with the active keyring key, or signingKey with the project ID as kid when the API is not in
+// keyring mode
+func (api *API) sign(claims Claims, signingKey []byte) (string, error) {
+	if api.keyring == nil {
+		token := jwt.NewWithClaims(api.signingMethod, claims)
+		if claims.Payload != nil {
+			token.Header["kid"] = claims.ProjectID
+		}
+		return token.SignedString(signingKey)
+	}
+
+	if signingKey != nil {
+		return "", errors.New("signing with a shared key is not supported in keyring mode")
+	}
+
+	key := api.keyring.Active()
+	if key == nil {
+		return "", errors.New("no active signing key in keyring")
+	}
 
-	token.Header["kid"] = claims.ProjectID
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
 
-	return token.SignedString(signingKey)
+	return token.SignedString(key.Private)
 }
 
 // Scheme returns authentication scheme
@@ -92,3 +190,17 @@ func DefaultAdminGroup() string {
 func DefaultSuperAdminGroup() string {
 	return "SUPER_ADMIN"
 }
+
+// ServiceTokenSource returns a function that mints tokens for payload which expire after ttl.
+//
+// It is intended for service-to-service calls and can be used as conn.GrpcDialOptions TokenSource.
+func (api *API) ServiceTokenSource(payload *Payload, ttl time.Duration) func(context.Context) (string, time.Time, error) {
+	return func(ctx context.Context) (string, time.Time, error) {
+		expires := time.Now().Add(ttl)
+		token, err := api.GenToken(ctx, payload, expires)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return token, expires, nil
+	}
+}
@@ -0,0 +1,308 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Key is an asymmetric key used to sign or verify tokens
+type Key struct {
+	// ID is the key identifier set as the kid header of tokens
+	ID string
+	// Method is the signing algorithm of the key
+	Method jwt.SigningMethod
+	// Private signs tokens, it is nil for keys that only verify tokens
+	Private crypto.Signer
+	// Public verifies tokens
+	Public crypto.PublicKey
+}
+
+// Keyring holds the keys of an API in keyring mode.
+//
+// One key is active for signing while every key in the keyring is accepted for verification, so keys can be rotated
+// by adding a new key, making it active and removing the old key once the tokens it signed have expired.
+// Services that only verify tokens hold public keys.
+type Keyring struct {
+	mu     sync.RWMutex
+	active string
+	keys   map[string]*Key
+	source *jwksSource
+}
+
+// NewKeyring creates an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*Key)}
+}
+
+// Add adds a key to the keyring, the key ID defaults to a thumbprint of the public key
+func (kr *Keyring) Add(key *Key) error {
+	switch {
+	case key == nil:
+		return errors.New("nil key not allowed")
+	case key.Public == nil && key.Private == nil:
+		return errors.New("missing key material")
+	}
+
+	if key.Public == nil {
+		key.Public = key.Private.Public()
+	}
+
+	if key.Method == nil {
+		method, err := signingMethodFor(key.Public)
+		if err != nil {
+			return err
+		}
+		key.Method = method
+	}
+
+	if key.ID == "" {
+		id, err := KeyID(key.Public)
+		if err != nil {
+			return err
+		}
+		key.ID = id
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.keys[key.ID]; ok {
+		return fmt.Errorf("key %s already in keyring", key.ID)
+	}
+
+	kr.keys[key.ID] = key
+
+	return nil
+}
+
+// AddPEM adds the private or public key, or certificate, in PEM encoded data.
+//
+// The algorithm is RS256 for RSA keys, ES256, ES384 or ES512 for ECDSA keys depending on the curve and EdDSA for
+// Ed25519 keys. It returns the key which ID defaults to a thumbprint of the public key.
+func (kr *Keyring) AddPEM(id string, data []byte) (*Key, error) {
+	key, err := ParsePEMKey(data)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = id
+	if err := kr.Add(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// AddPEMFile works like AddPEM reading the PEM data from a file
+func (kr *Keyring) AddPEMFile(id, path string) (*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	return kr.AddPEM(id, data)
+}
+
+// SetActive makes the key with id the key that signs new tokens, it must have a private key
+func (kr *Keyring) SetActive(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	key, ok := kr.keys[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("key %s not in keyring", id)
+	case key.Private == nil:
+		return fmt.Errorf("key %s has no private key for signing", id)
+	}
+
+	kr.active = id
+
+	return nil
+}
+
+// Remove removes the key with id, tokens it signed are no longer accepted.
+//
+// Removing the active key leaves the keyring without a signing key.
+func (kr *Keyring) Remove(id string) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	delete(kr.keys, id)
+	if kr.active == id {
+		kr.active = ""
+	}
+}
+
+// Active returns the key that signs new tokens or nil when there is none
+func (kr *Keyring) Active() *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.keys[kr.active]
+}
+
+// Key returns the key with id
+func (kr *Keyring) Key(id string) (*Key, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, ok := kr.keys[id]
+	return key, ok
+}
+
+// Keys returns the keys in the keyring sorted by ID
+func (kr *Keyring) Keys() []*Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	keys := make([]*Key, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys
+}
+
+// replace replaces the keys of the keyring
+func (kr *Keyring) replace(keys []*Key) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.keys = make(map[string]*Key, len(keys))
+	for _, key := range keys {
+		kr.keys[key.ID] = key
+	}
+	if _, ok := kr.keys[kr.active]; !ok {
+		kr.active = ""
+	}
+}
+
+// KeyID returns an identifier for the public key derived from the SHA-256 hash of its PKIX encoding
+func KeyID(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key: %v", err)
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:16]), nil
+}
+
+// ParsePEMKey parses a PKCS #1, PKCS #8, SEC 1 or PKIX encoded key or an X.509 certificate
+func ParsePEMKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var (
+		parsed interface{}
+		err    error
+	)
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			parsed = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", block.Type, err)
+	}
+
+	key := &Key{}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		key.Public = signer.Public()
+	} else {
+		key.Public = parsed
+	}
+
+	key.Method, err = signingMethodFor(key.Public)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// signingMethodFor returns the signing method for the public key type
+func signingMethodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported ECDSA curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+}
+
+// keyFunc returns the key that verifies the token, checking the token algorithm matches the key.
+//
+// Tokens with known key ids never wait for a refresh of the key source.
+func (kr *Keyring) keyFunc(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	if id == "" {
+		return nil, errors.New("token has no key id")
+	}
+
+	if kr.source != nil {
+		kr.source.refreshAsync(kr)
+	}
+
+	key, ok := kr.Key(id)
+	if !ok && kr.source != nil {
+		// The issuer may have rotated keys
+		_ = kr.source.refresh(ctx, kr, true)
+		key, ok = kr.Key(id)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", id)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, tokenError(ErrTokenAlgorithm, "algorithm %s for key %s", token.Method.Alg(), id)
+	}
+
+	return key.Public, nil
+}
@@ -0,0 +1,244 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc/metadata"
+)
+
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := parsed.Header["kid"].(string)
+	return id
+}
+
+func TestKeyringRotation(t *testing.T) {
+	ctx := context.Background()
+	signers := generateKeys(t)
+
+	kr := NewKeyring()
+	oldKey, newKey := &Key{Private: signers[0]}, &Key{Private: signers[1]}
+	if err := kr.Add(oldKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.SetActive(oldKey.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewAPIWithKeyring(kr, "issuer", "audience")
+
+	oldToken, err := api.GenToken(ctx, &Payload{ID: "1"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to the new key, tokens signed with the old key are still accepted
+	if err := kr.Add(newKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.SetActive(newKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := api.GenToken(ctx, &Payload{ID: "1"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := tokenKeyID(t, newToken); id != newKey.ID {
+		t.Fatalf("expected kid %s got %s", newKey.ID, id)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if err := authenticate(api, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Services that only verify tokens hold the public keys
+	verifier := NewKeyring()
+	if err := verifier.Add(&Key{Public: newKey.Public}); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.SetActive(newKey.ID); err == nil {
+		t.Fatal("expected error activating a public key")
+	}
+	verifyAPI := NewAPIWithKeyring(verifier, "issuer", "audience")
+	if err := authenticate(verifyAPI, newToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := authenticate(verifyAPI, oldToken); !errors.Is(err, ErrTokenSignature) {
+		t.Fatalf("expected signature error got %v", err)
+	}
+	if _, err := verifyAPI.GenToken(ctx, &Payload{ID: "1"}, time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("expected error signing with a verify only keyring")
+	}
+
+	// Removing the old key rejects the tokens it signed
+	kr.Remove(oldKey.ID)
+	if err := authenticate(api, oldToken); !errors.Is(err, ErrTokenSignature) {
+		t.Fatalf("expected signature error got %v", err)
+	}
+	if err := authenticate(api, newToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the active key leaves no signing key
+	kr.Remove(newKey.ID)
+	if kr.Active() != nil {
+		t.Fatal("expected no active key")
+	}
+	if _, err := api.GenToken(ctx, &Payload{ID: "1"}, time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("expected error signing without an active key")
+	}
+}
+
+func TestAuthenticatorWithKeyAlgorithm(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewKeyring()
+	if err := kr.Add(&Key{Private: ecKey}); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPIWithKeyring(kr, "issuer", "audience")
+
+	now := time.Now()
+	claims := Claims{Payload: &Payload{ID: "1"}, StandardClaims: jwt.StandardClaims{
+		Issuer:    "issuer",
+		Audience:  "audience",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"hmac", signed(t, jwt.SigningMethodHS384, testSigningKey, claims), nil},
+		{"asymmetric", signed(t, jwt.SigningMethodES256, ecKey, claims), ErrTokenAlgorithm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.Pairs(Header(), Scheme()+" "+tt.token)
+			_, err := api.AuthenticatorWithKey(metadata.NewIncomingContext(context.Background(), md), testSigningKey)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSharedKeyID(t *testing.T) {
+	api := NewAPI(testSigningKey, "issuer", "audience")
+
+	token, err := api.GenToken(context.Background(), &Payload{ID: "1", ProjectID: "project"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := tokenKeyID(t, token); id != "project" {
+		t.Fatalf("expected kid project got %q", id)
+	}
+}
+
+func TestParsePEMKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "issuer"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "issuer"}}, rsaKey.Public(), rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		block   *pem.Block
+		method  jwt.SigningMethod
+		private bool
+	}{
+		{"pkcs8", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}, jwt.SigningMethodEdDSA, true},
+		{"pkcs1", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, jwt.SigningMethodRS256, true},
+		{"sec1", &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}, jwt.SigningMethodES256, true},
+		{"pkix", &pem.Block{Type: "PUBLIC KEY", Bytes: public}, jwt.SigningMethodES256, false},
+		{"pkcs1 public", &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)}, jwt.SigningMethodRS256, false},
+		{"certificate", &pem.Block{Type: "CERTIFICATE", Bytes: cert}, jwt.SigningMethodRS256, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePEMKey(pem.EncodeToMemory(tt.block))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Method != tt.method {
+				t.Fatalf("expected method %s got %s", tt.method.Alg(), key.Method.Alg())
+			}
+			if (key.Private != nil) != tt.private || key.Public == nil {
+				t.Fatalf("unexpected key material %+v", key)
+			}
+		})
+	}
+
+	for name, data := range map[string][]byte{
+		"no pem":      []byte("not a key"),
+		"unsupported": pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: []byte{1}}),
+		"corrupt":     pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1, 2, 3}}),
+	} {
+		if _, err := ParsePEMKey(data); err == nil {
+			t.Fatalf("expected error parsing %s key", name)
+		}
+	}
+
+	kr := NewKeyring()
+	key, err := kr.AddPEM("", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != mustKeyID(t, ecKey) {
+		t.Fatalf("expected thumbprint key id got %s", key.ID)
+	}
+}
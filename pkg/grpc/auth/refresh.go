@@ -0,0 +1,258 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Token types set in the token_type claim
+const (
+	TokenTypeAccess  = ""
+	TokenTypeRefresh = "refresh"
+)
+
+// Errors returned for revoked tokens, wrapped in a *TokenError
+var (
+	ErrTokenType    = errors.New("token type not accepted")
+	ErrTokenRevoked = errors.New("token is revoked")
+	ErrTokenReused  = errors.New("refresh token already used")
+)
+
+// Denylist stores revoked tokens.
+//
+// Tokens are revoked by their jti claim until they expire, and by subject for tokens issued before a time.
+type Denylist interface {
+	// RevokeToken revokes the token with id until expires, added is false when the token was already revoked.
+	// It must be atomic as refresh token rotation relies on it to detect reuse.
+	RevokeToken(ctx context.Context, id string, expires time.Time) (added bool, err error)
+	// RevokeSubject revokes the tokens of subject issued before the given time
+	RevokeSubject(ctx context.Context, subject string, before time.Time) error
+	// IsRevoked checks whether the token with id, subject and issue time is revoked
+	IsRevoked(ctx context.Context, id, subject string, issuedAt time.Time) (bool, error)
+}
+
+// TokenPair contains an access token and the refresh token that renews it
+type TokenPair struct {
+	AccessToken    string
+	AccessExpires  time.Time
+	RefreshToken   string
+	RefreshExpires time.Time
+}
+
+// SetDenylist sets the store of revoked tokens, it must be called before the API is used.
+//
+// Authenticator rejects revoked tokens and refresh tokens are rotated on use once a denylist is set.
+func (api *API) SetDenylist(denylist Denylist) {
+	api.denylist = denylist
+}
+
+// GenTokenPair generates an access token and a refresh token for payload.
+//
+// The refresh token is exchanged for a new pair with RefreshTokenPair and cannot be used as an access token.
+func (api *API) GenTokenPair(ctx context.Context, payload *Payload, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+	switch {
+	case api.denylist == nil:
+		return nil, errors.New("refresh tokens require a denylist")
+	case payload == nil:
+		return nil, errors.New("nil payload not allowed")
+	case accessTTL <= 0:
+		return nil, errors.New("access token ttl must be positive")
+	case refreshTTL <= 0:
+		return nil, errors.New("refresh token ttl must be positive")
+	}
+
+	now := time.Now()
+
+	pair := &TokenPair{
+		AccessExpires:  now.Add(accessTTL),
+		RefreshExpires: now.Add(refreshTTL),
+	}
+
+	var err error
+	pair.AccessToken, err = api.genPairToken(payload, TokenTypeAccess, now, pair.AccessExpires)
+	if err != nil {
+		return nil, err
+	}
+	pair.RefreshToken, err = api.genPairToken(payload, TokenTypeRefresh, now, pair.RefreshExpires)
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func (api *API) genPairToken(payload *Payload, tokenType string, now, expires time.Time) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	return api.sign(Claims{
+		Payload: payload,
+		Type:    tokenType,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  api.audience,
+			ExpiresAt: expires.Unix(),
+			Id:        id,
+			IssuedAt:  now.Unix(),
+			Issuer:    api.issuer,
+			Subject:   payload.ID,
+		},
+	}, api.signingKey)
+}
+
+// RefreshTokenPair exchanges a refresh token for a new token pair with the same payload.
+//
+// The refresh token is revoked so it can only be used once. Using it again means it leaked, so every token of the
+// subject issued before the current second is revoked and ErrTokenReused is returned; the user has to authenticate again.
+func (api *API) RefreshTokenPair(ctx context.Context, refreshToken string, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+	if api.denylist == nil {
+		return nil, errors.New("refresh tokens require a denylist")
+	}
+
+	claims, err := api.parseToken(ctx, refreshToken, api.signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claims.Type != TokenTypeRefresh:
+		return nil, tokenError(ErrTokenType, "expected a refresh token")
+	case claims.Id == "" || claims.Payload == nil:
+		return nil, tokenError(ErrTokenMissingClaim, "claim %s", ClaimID)
+	}
+
+	// A revoked refresh token id means reuse, only check the subject here
+	if err := api.checkRevoked(ctx, "", claims); err != nil {
+		return nil, err
+	}
+
+	added, err := api.denylist.RevokeToken(ctx, claims.Id, revokeUntil(claims))
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to rotate refresh token: %v", err)
+	}
+
+	if !added {
+		subject := subjectOf(claims)
+		if err := api.denylist.RevokeSubject(ctx, subject, revokeBefore()); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to revoke tokens: %v", err)
+		}
+		if api.logger != nil {
+			api.logger.Warningf("refresh token %s reused, revoked tokens of subject %s", claims.Id, subject)
+		}
+		return nil, tokenError(ErrTokenReused, "token %s", claims.Id)
+	}
+
+	return api.GenTokenPair(ctx, claims.Payload, accessTTL, refreshTTL)
+}
+
+// RevokeToken revokes an access or refresh token until it expires, such as on logout.
+//
+// Expired tokens are ignored since they are no longer accepted, tokens without exp are revoked indefinitely.
+func (api *API) RevokeToken(ctx context.Context, token string) error {
+	if api.denylist == nil {
+		return errors.New("token revocation requires a denylist")
+	}
+
+	claims, err := api.parseToken(ctx, token, api.signingKey)
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		return nil
+	case err != nil:
+		return err
+	case claims.Id == "":
+		return tokenError(ErrTokenMissingClaim, "claim %s", ClaimID)
+	}
+
+	_, err = api.denylist.RevokeToken(ctx, claims.Id, revokeUntil(claims))
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to revoke token: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeSubject revokes every token issued so far to subject, such as after a password reset or account compromise.
+//
+// The subject is the sub claim, or the payload ID for tokens without one. As iat has second precision, tokens issued
+// within the same second as the revocation stay valid so that the user can authenticate again right away.
+func (api *API) RevokeSubject(ctx context.Context, subject string) error {
+	switch {
+	case api.denylist == nil:
+		return errors.New("token revocation requires a denylist")
+	case subject == "":
+		return errors.New("missing subject")
+	}
+
+	err := api.denylist.RevokeSubject(ctx, subject, revokeBefore())
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to revoke tokens: %v", err)
+	}
+
+	return nil
+}
+
+// checkAccess checks an authenticated token is a usable access token
+func (api *API) checkAccess(ctx context.Context, claims *Claims) error {
+	if claims.Type != TokenTypeAccess {
+		return tokenError(ErrTokenType, "token type %s", claims.Type)
+	}
+	return api.checkRevoked(ctx, claims.Id, claims)
+}
+
+// checkRevoked checks the token id, when not empty, and the token subject are not in the denylist
+func (api *API) checkRevoked(ctx context.Context, id string, claims *Claims) error {
+	if api.denylist == nil {
+		return nil
+	}
+
+	revoked, err := api.denylist.IsRevoked(ctx, id, subjectOf(claims), time.Unix(claims.IssuedAt, 0))
+	switch {
+	case err != nil:
+		return status.Errorf(codes.Unavailable, "failed to check token revocation: %v", err)
+	case revoked:
+		return tokenError(ErrTokenRevoked, "token %s of subject %s", claims.Id, subjectOf(claims))
+	}
+
+	return nil
+}
+
+// neverExpires is the revocation expiry of tokens without exp, it fits DATETIME columns
+var neverExpires = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// revokeUntil returns how long the token must stay revoked
+func revokeUntil(claims *Claims) time.Time {
+	if claims.ExpiresAt == 0 {
+		return neverExpires
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+// revokeBefore returns the time before which tokens of a revoked subject were issued, truncated to the second
+// precision of iat
+func revokeBefore() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+func subjectOf(claims *Claims) string {
+	if claims.Subject != "" || claims.Payload == nil {
+		return claims.Subject
+	}
+	return claims.Payload.ID
+}
+
+func newTokenID() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
@@ -0,0 +1,112 @@
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func authenticate(api *API, token string) error {
+	md := metadata.Pairs(Header(), Scheme()+" "+token)
+	_, err := api.Authenticator(metadata.NewIncomingContext(context.Background(), md))
+	return err
+}
+
+// waitNextSecond waits for the next second so that tokens issued so far are before a subject revocation
+func waitNextSecond() {
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+}
+
+func TestRefreshTokenPair(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:denylist?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	gormDenylist, err := NewGormDenylist(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, denylist := range map[string]Denylist{"memory": NewMemoryDenylist(), "gorm": gormDenylist} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			api := NewAPI(testSigningKey, "issuer", "audience")
+			api.SetDenylist(denylist)
+
+			payload := &Payload{ID: "user-" + name, Group: DefaultUserGroup()}
+
+			pair, err := api.GenTokenPair(ctx, payload, time.Minute, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := authenticate(api, pair.AccessToken); err != nil {
+				t.Fatalf("access token rejected: %v", err)
+			}
+			if err := authenticate(api, pair.RefreshToken); !errors.Is(err, ErrTokenType) {
+				t.Fatalf("expected refresh token to be rejected as access token, got %v", err)
+			}
+			if _, err := api.RefreshTokenPair(ctx, pair.AccessToken, time.Minute, time.Hour); !errors.Is(err, ErrTokenType) {
+				t.Fatalf("expected access token to be rejected as refresh token, got %v", err)
+			}
+
+			// Logout revokes the access token
+			if err := api.RevokeToken(ctx, pair.AccessToken); err != nil {
+				t.Fatal(err)
+			}
+			if err := authenticate(api, pair.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+				t.Fatalf("expected revoked access token, got %v", err)
+			}
+
+			rotated, err := api.RefreshTokenPair(ctx, pair.RefreshToken, time.Minute, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := authenticate(api, rotated.AccessToken); err != nil {
+				t.Fatalf("rotated access token rejected: %v", err)
+			}
+
+			// Reusing the old refresh token revokes every token of the subject issued before the current second
+			waitNextSecond()
+			if _, err := api.RefreshTokenPair(ctx, pair.RefreshToken, time.Minute, time.Hour); !errors.Is(err, ErrTokenReused) {
+				t.Fatalf("expected reused refresh token, got %v", err)
+			}
+			if err := authenticate(api, rotated.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+				t.Fatalf("expected access token of revoked subject, got %v", err)
+			}
+			if _, err := api.RefreshTokenPair(ctx, rotated.RefreshToken, time.Minute, time.Hour); !errors.Is(err, ErrTokenRevoked) {
+				t.Fatalf("expected refresh token of revoked subject, got %v", err)
+			}
+
+			// The user can authenticate again right after revocation
+			relogin, err := api.GenTokenPair(ctx, payload, time.Minute, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := authenticate(api, relogin.AccessToken); err != nil {
+				t.Fatalf("access token issued after revocation rejected: %v", err)
+			}
+
+			// Tokens without exp are revoked indefinitely
+			noExp, err := api.GenTokenFromClaims(ctx, &Claims{Payload: payload}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := authenticate(api, noExp); err != nil {
+				t.Fatalf("token without exp rejected: %v", err)
+			}
+			if err := api.RevokeToken(ctx, noExp); err != nil {
+				t.Fatal(err)
+			}
+			if err := authenticate(api, noExp); !errors.Is(err, ErrTokenRevoked) {
+				t.Fatalf("expected revoked token without exp, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package grpcauth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+)
+
+// Errors returned for tokens that fail validation, wrapped in a *TokenError
+var (
+	ErrTokenMalformed    = errors.New("token is malformed")
+	ErrTokenSignature    = errors.New("token signature is invalid")
+	ErrTokenAlgorithm    = errors.New("token signing algorithm not allowed")
+	ErrTokenExpired      = errors.New("token is expired")
+	ErrTokenNotValidYet  = errors.New("token is not valid yet")
+	ErrTokenIssuer       = errors.New("token issuer not accepted")
+	ErrTokenAudience     = errors.New("token audience not accepted")
+	ErrTokenMissingClaim = errors.New("token is missing a required claim")
+)
+
+// TokenError is returned when a token fails validation.
+//
+// It wraps one of the ErrToken errors which can be checked with errors.Is and converts to a codes.Unauthenticated
+// status carrying only the wrapped error message, the detail is meant for logs.
+type TokenError struct {
+	Err    error
+	Detail string
+}
+
+func (e *TokenError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Err, e.Detail)
+}
+
+// Unwrap returns the wrapped validation error
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the codes.Unauthenticated status of the error
+func (e *TokenError) GRPCStatus() *status.Status {
+	return status.New(codes.Unauthenticated, e.Err.Error())
+}
+
+func tokenError(err error, format string, args ...interface{}) *TokenError {
+	return &TokenError{Err: err, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Registered claims that can be required by ValidationOptions
+const (
+	ClaimExpiresAt = "exp"
+	ClaimNotBefore = "nbf"
+	ClaimIssuedAt  = "iat"
+	ClaimIssuer    = "iss"
+	ClaimAudience  = "aud"
+	ClaimSubject   = "sub"
+	ClaimID        = "jti"
+)
+
+// ValidationOptions contains options for validating tokens
+type ValidationOptions struct {
+	// Algorithms are the accepted signing algorithms.
+	// It defaults to the API signing method; in keyring mode every token must match the algorithm of its key.
+	Algorithms []string
+	// Issuers are the accepted token issuers, defaults to the API issuer
+	Issuers []string
+	// Audiences are the accepted token audiences, defaults to the API audience.
+	// Tokens with several audiences are accepted when any of them is accepted.
+	Audiences []string
+	// Leeway is the clock skew allowed when checking exp, nbf and iat
+	Leeway time.Duration
+	// RequiredClaims are registered claims that must be set such as ClaimExpiresAt or ClaimID
+	RequiredClaims []string
+}
+
+// SetValidation sets the options for validating tokens, it must be called before the API is used
+func (api *API) SetValidation(opt *ValidationOptions) error {
+	if opt == nil {
+		opt = &ValidationOptions{}
+	}
+
+	v := *opt
+
+	switch {
+	case v.Leeway < 0:
+		return errors.New("jwt leeway must not be negative")
+	case len(v.Algorithms) == 0 && api.keyring == nil:
+		v.Algorithms = []string{api.signingMethod.Alg()}
+	}
+
+	for _, alg := range v.Algorithms {
+		if jwt.GetSigningMethod(alg) == nil {
+			return fmt.Errorf("unknown jwt signing algorithm %q", alg)
+		}
+	}
+
+	for _, claim := range v.RequiredClaims {
+		switch claim {
+		case ClaimExpiresAt, ClaimNotBefore, ClaimIssuedAt, ClaimIssuer, ClaimAudience, ClaimSubject, ClaimID:
+		default:
+			return fmt.Errorf("unsupported required claim %q", claim)
+		}
+	}
+
+	if len(v.Issuers) == 0 {
+		v.Issuers = []string{api.issuer}
+	}
+	if len(v.Audiences) == 0 {
+		v.Audiences = []string{api.audience}
+	}
+
+	api.validation = v
+
+	return nil
+}
+
+// SetLogger sets the logger for authentication failures, no logging is done when nil
+func (api *API) SetLogger(logger grpclog.LoggerV2) {
+	api.logger = logger
+}
+
+// checkAlgorithm checks the token signing algorithm is allowed
+func (api *API) checkAlgorithm(token *jwt.Token) error {
+	if len(api.validation.Algorithms) == 0 {
+		return nil
+	}
+	alg := token.Method.Alg()
+	for _, allowed := range api.validation.Algorithms {
+		if alg == allowed {
+			return nil
+		}
+	}
+	return tokenError(ErrTokenAlgorithm, "algorithm %s", alg)
+}
+
+// parseError converts errors from the jwt parser to a *TokenError
+func parseError(err error) error {
+	var ve *jwt.ValidationError
+	if !errors.As(err, &ve) {
+		return tokenError(ErrTokenMalformed, "%v", err)
+	}
+
+	var te *TokenError
+	switch {
+	case errors.As(ve.Inner, &te):
+		return te
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		return tokenError(ErrTokenMalformed, "%v", err)
+	default:
+		return tokenError(ErrTokenSignature, "%v", err)
+	}
+}
+
+// validateClaims checks the registered claims of a token with a verified signature
+func (api *API) validateClaims(claims *Claims) error {
+	var (
+		v      = api.validation
+		now    = time.Now().Unix()
+		leeway = int64(v.Leeway / time.Second)
+	)
+
+	for _, claim := range v.RequiredClaims {
+		var missing bool
+		switch claim {
+		case ClaimExpiresAt:
+			missing = claims.ExpiresAt == 0
+		case ClaimNotBefore:
+			missing = claims.NotBefore == 0
+		case ClaimIssuedAt:
+			missing = claims.IssuedAt == 0
+		case ClaimIssuer:
+			missing = claims.Issuer == ""
+		case ClaimAudience:
+			missing = len(audiences(claims)) == 0
+		case ClaimSubject:
+			missing = claims.Subject == ""
+		case ClaimID:
+			missing = claims.Id == ""
+		}
+		if missing {
+			return tokenError(ErrTokenMissingClaim, "claim %s", claim)
+		}
+	}
+
+	switch {
+	case claims.ExpiresAt != 0 && now > claims.ExpiresAt+leeway:
+		return tokenError(ErrTokenExpired, "expired %s ago", time.Duration(now-claims.ExpiresAt)*time.Second)
+	case claims.NotBefore != 0 && now+leeway < claims.NotBefore:
+		return tokenError(ErrTokenNotValidYet, "not before %s", time.Unix(claims.NotBefore, 0).UTC())
+	case claims.IssuedAt != 0 && now+leeway < claims.IssuedAt:
+		return tokenError(ErrTokenNotValidYet, "issued at %s", time.Unix(claims.IssuedAt, 0).UTC())
+	case !contains(v.Issuers, claims.Issuer):
+		return tokenError(ErrTokenIssuer, "issuer %q", claims.Issuer)
+	case !containsAny(v.Audiences, audiences(claims)):
+		return tokenError(ErrTokenAudience, "audience %q", audiences(claims))
+	}
+
+	return nil
+}
+
+// audiences returns the audiences of the aud claim, which may be a list
+func audiences(claims *Claims) []string {
+	if len(claims.Audiences) > 0 {
+		return claims.Audiences
+	}
+	if claims.Audience == "" {
+		return nil
+	}
+	return []string{claims.Audience}
+}
+
+func containsAny(vals, candidates []string) bool {
+	for _, candidate := range candidates {
+		if contains(vals, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testSigningKey = []byte("validation-test-signing-key")
+
+func signed(t *testing.T, method jwt.SigningMethod, key interface{}, claims Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func signedAudience(t *testing.T, now time.Time, aud interface{}) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ID":  "1",
+		"iss": "issuer",
+		"aud": aud,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}).SignedString(testSigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestValidation(t *testing.T) {
+	api := NewAPI(testSigningKey, "issuer", "audience")
+	err := api.SetValidation(&ValidationOptions{
+		Audiences:      []string{"audience", "other"},
+		Leeway:         time.Minute,
+		RequiredClaims: []string{ClaimExpiresAt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid := func(modify func(*jwt.StandardClaims)) Claims {
+		c := Claims{Payload: &Payload{ID: "1"}, StandardClaims: jwt.StandardClaims{
+			Issuer:    "issuer",
+			Audience:  "audience",
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		}}
+		if modify != nil {
+			modify(&c.StandardClaims)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", signed(t, jwt.SigningMethodHS256, testSigningKey, valid(nil)), nil},
+		{"other audience", signed(t, jwt.SigningMethodHS256, testSigningKey, valid(func(c *jwt.StandardClaims) {
+			c.Audience = "other"
+		})), nil},
+		{"expired within leeway", signed(t, jwt.SigningMethodHS256, testSigningKey, valid(func(c *jwt.StandardClaims) {
+			c.ExpiresAt = now.Add(-30 * time.Second).Unix()
+		})), nil},
+		{"malformed", "not.a.token", ErrTokenMalformed},
+		{"bad signature", signed(t, jwt.SigningMethodHS256, []byte("other-key"), valid(nil)), ErrTokenSignature},
+		{"algorithm", signed(t, jwt.SigningMethodHS512, testSigningKey, valid(nil)), ErrTokenAlgorithm},
+		{"asymmetric algorithm", signed(t, jwt.SigningMethodES256, ecKey, valid(nil)), ErrTokenAlgorithm},
+		{"expired", signed(t, jwt.SigningMethodHS256, testSigningKey, valid(func(c *jwt.StandardClaims) {
+			c.ExpiresAt = now.Add(-2 * time.Minute).Unix()
+		})), ErrTokenExpired},
+		{"not before", signed(t, jwt.SigningMethodHS256, testSigningKey, valid(func(c *jwt.StandardClaims) {
+			c.NotBefore = now.Add(2 * time.Minute).Unix()
+		})), ErrTokenNotValidYet},
+		{"issuer", signed(t, jwt.SigningMethodHS256, testSigningKey, valid(func(c *jwt.StandardClaims) {
+			c.Issuer = "someone"
+		})), ErrTokenIssuer},
+		{"audience", signed(t, jwt.SigningMethodHS256, testSigningKey, valid(func(c *jwt.StandardClaims) {
+			c.Audience = "someone"
+		})), ErrTokenAudience},
+		{"audience list", signedAudience(t, now, []string{"someone", "other"}), nil},
+		{"audience list not accepted", signedAudience(t, now, []string{"someone", "else"}), ErrTokenAudience},
+		{"audience malformed", signedAudience(t, now, 42), ErrTokenMalformed},
+		{"missing exp", signed(t, jwt.SigningMethodHS256, testSigningKey, valid(func(c *jwt.StandardClaims) {
+			c.ExpiresAt = 0
+		})), ErrTokenMissingClaim},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.Pairs(Header(), Scheme()+" "+tt.token)
+			_, err := api.Authenticator(metadata.NewIncomingContext(context.Background(), md))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v got %v", tt.err, err)
+			}
+			if err != nil && status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("expected code %s got %s", codes.Unauthenticated, status.Code(err))
+			}
+		})
+	}
+}
+
+func TestGenTokenFromClaims(t *testing.T) {
+	api := NewAPI(testSigningKey, "issuer", "audience")
+
+	token, err := api.GenTokenFromClaims(context.Background(), &Claims{Payload: &Payload{ID: "1"}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := api.GetClaimsFromJwt(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "issuer" || claims.Audience != "audience" || claims.ExpiresAt == 0 {
+		t.Fatalf("registered claims not set: %+v", claims.StandardClaims)
+	}
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gidyon/gomicro/pkg/tenant"
+	"google.golang.org/grpc"
+)
+
+// AddTenant returns interceptors that resolve the tenant of requests, they must be chained after authentication.
+func AddTenant(
+	resolver *tenant.Resolver,
+) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	// Add unary interceptors
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		resolver.UnaryServerInterceptor(),
+	}
+
+	// Add stream interceptors
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		resolver.StreamServerInterceptor(),
+	}
+
+	return unaryInterceptors, streamInterceptors
+}
@@ -0,0 +1,394 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTable is the default table that records applied migrations
+const DefaultTable = "schema_migrations"
+
+// Migration is a versioned schema change with its up and down SQL
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is the state of a migration in the database
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Options contains options for creating a Migrator
+type Options struct {
+	// Dialect is one of mysql, postgres or sqlite, defaults to mysql
+	Dialect string
+	// Table records applied migrations, defaults to DefaultTable
+	Table string
+	// LockName identifies the advisory lock, defaults to the table name
+	LockName string
+	// LockTimeout is how long to wait for the advisory lock, defaults to 1 minute
+	LockTimeout time.Duration
+}
+
+// Migrator applies and rolls back versioned SQL migrations.
+//
+// Migrations are read from files named <version>_<name>.up.sql and <version>_<name>.down.sql.
+// Each migration runs inside a transaction; note that MySQL commits DDL implicitly and
+// needs the multiStatements driver parameter for files with more than one statement.
+type Migrator struct {
+	db         *sql.DB
+	opt        Options
+	migrations []*Migration
+}
+
+var fileNameRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// New creates a migrator reading migration files from dir in fsys, which can be an embed.FS or os.DirFS
+func New(db *sql.DB, fsys fs.FS, dir string, opt *Options) (*Migrator, error) {
+	switch {
+	case db == nil:
+		return nil, errors.New("nil db not allowed")
+	case fsys == nil:
+		return nil, errors.New("nil migrations fs not allowed")
+	}
+
+	m := &Migrator{db: db}
+	if opt != nil {
+		m.opt = *opt
+	}
+	if m.opt.Dialect == "" {
+		m.opt.Dialect = "mysql"
+	}
+	if m.opt.Table == "" {
+		m.opt.Table = DefaultTable
+	}
+	if m.opt.LockName == "" {
+		m.opt.LockName = m.opt.Table
+	}
+	if m.opt.LockTimeout <= 0 {
+		m.opt.LockTimeout = time.Minute
+	}
+
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %v", err)
+	}
+
+	migrations := map[int64]*Migration{}
+
+	for _, entry := range entries {
+		matches := fileNameRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %v", entry.Name(), err)
+		}
+
+		bs, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", entry.Name(), err)
+		}
+
+		migration, ok := migrations[version]
+		switch {
+		case !ok:
+			migration = &Migration{Version: version, Name: matches[2]}
+			migrations[version] = migration
+		case migration.Name != matches[2]:
+			return nil, fmt.Errorf("duplicate migration version %d", version)
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(bs)
+		} else {
+			migration.Down = string(bs)
+		}
+	}
+
+	for _, migration := range migrations {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("missing up migration for version %d", migration.Version)
+		}
+		m.migrations = append(m.migrations, migration)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+
+	return m, nil
+}
+
+// Migrations returns the migrations sorted by version
+func (m *Migrator) Migrations() []*Migration {
+	return m.migrations
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// To migrates the schema up or down to version, a version of 0 rolls back every migration
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// Apply pending migrations up to version
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, true); err != nil {
+				return err
+			}
+		}
+
+		// Roll back applied migrations above version
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version <= version {
+				break
+			}
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, false); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Rollback rolls back the last steps applied migrations
+func (m *Migrator) Rollback(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, false); err != nil {
+				return err
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Status returns the status of every known migration
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := m.createTable(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, &Status{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return statuses, nil
+}
+
+// Version returns the highest applied migration version or 0 when none is applied
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for _, status := range statuses {
+		if status.Applied {
+			version = status.Version
+		}
+	}
+	return version, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration *Migration, up bool) error {
+	query, direction := migration.Up, "up"
+	if !up {
+		query, direction = migration.Down, "down"
+		if query == "" {
+			return fmt.Errorf("missing down migration for version %d", migration.Version)
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to migrate %s version %d (%s): %v", direction, migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.bind(fmt.Sprintf(
+			"INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)", m.opt.Table,
+		)), migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, m.bind(fmt.Sprintf(
+			"DELETE FROM %s WHERE version = ?", m.opt.Table,
+		)), migration.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration version %d: %v", migration.Version, err)
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", m.opt.Table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)",
+		m.opt.Table,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+	return nil
+}
+
+// withLock runs fn on a single connection holding the advisory lock so that concurrent replicas don't race
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch m.opt.Dialect {
+	case "mysql":
+		var locked sql.NullInt64
+		err = conn.QueryRowContext(
+			ctx, "SELECT GET_LOCK(?, ?)", m.opt.LockName, int(m.opt.LockTimeout.Seconds()),
+		).Scan(&locked)
+		if err == nil && locked.Int64 != 1 {
+			err = errors.New("timed out")
+		}
+		defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", m.opt.LockName)
+	case "postgres", "postgresql":
+		lockCtx, cancel := context.WithTimeout(ctx, m.opt.LockTimeout)
+		defer cancel()
+		key := int64(crc32.ChecksumIEEE([]byte(m.opt.LockName)))
+		_, err = conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", key)
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+	case "sqlite", "sqlite3":
+		// SQLite serializes writers, there is no advisory lock
+	default:
+		return fmt.Errorf("unsupported migrations dialect %q", m.opt.Dialect)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire migrations lock: %v", err)
+	}
+
+	if err := m.createTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// bind rewrites ? placeholders for the dialect
+func (m *Migrator) bind(query string) string {
+	if m.opt.Dialect != "postgres" && m.opt.Dialect != "postgresql" {
+		return query
+	}
+	var (
+		sb strings.Builder
+		n  int
+	)
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
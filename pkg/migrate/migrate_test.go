@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMigrator(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:migrate_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fsys := fstest.MapFS{
+		"migrations/0001_users.up.sql":    {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY)")},
+		"migrations/0001_users.down.sql":  {Data: []byte("DROP TABLE users")},
+		"migrations/0002_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id INTEGER PRIMARY KEY)")},
+		"migrations/0002_orders.down.sql": {Data: []byte("DROP TABLE orders")},
+		"migrations/README.md":            {Data: []byte("ignored")},
+	}
+
+	m, err := New(db, fsys, "migrations", &Options{Dialect: "sqlite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	version := func() int64 {
+		t.Helper()
+		v, err := m.Version(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if got := version(); got != 2 {
+		t.Errorf("Version() after Up = %d, want 2", got)
+	}
+
+	if err := m.Rollback(ctx, 1); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if got := version(); got != 1 {
+		t.Errorf("Version() after Rollback = %d, want 1", got)
+	}
+	if _, err := db.Exec("SELECT * FROM orders"); err == nil {
+		t.Error("orders table should be dropped after rollback")
+	}
+
+	if err := m.To(ctx, 0); err != nil {
+		t.Fatalf("To(0) error = %v", err)
+	}
+	if got := version(); got != 0 {
+		t.Errorf("Version() after To(0) = %d, want 0", got)
+	}
+}
@@ -0,0 +1,152 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gidyon/gomicro/utils/dbutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultTable is the default table that stores outbox events
+const DefaultTable = "outbox_events"
+
+// Status is the delivery state of an outbox event
+type Status string
+
+// Outbox event statuses
+const (
+	StatusPending Status = "pending"
+	StatusDone    Status = "done"
+	StatusDead    Status = "dead"
+)
+
+// Message is an event to publish
+type Message struct {
+	Topic   string
+	Key     string
+	Payload []byte
+	Headers map[string]string
+}
+
+// Event is a message stored in the outbox table
+type Event struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	Topic       string `gorm:"type:varchar(255);not null"`
+	Key         string `gorm:"type:varchar(255)"`
+	Payload     []byte
+	Headers     string    `gorm:"type:text"`
+	Status      Status    `gorm:"type:varchar(16);not null"`
+	Attempts    int       `gorm:"not null"`
+	LastError   string    `gorm:"type:text"`
+	AvailableAt time.Time `gorm:"not null"`
+	CreatedAt   time.Time
+	PublishedAt *time.Time
+}
+
+// TableName is the default outbox table name
+func (*Event) TableName() string {
+	return DefaultTable
+}
+
+// Message returns the message stored in the event
+func (e *Event) Message() (*Message, error) {
+	msg := &Message{Topic: e.Topic, Key: e.Key, Payload: e.Payload}
+	if e.Headers != "" {
+		if err := json.Unmarshal([]byte(e.Headers), &msg.Headers); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal headers of outbox event %d: %v", e.ID, err)
+		}
+	}
+	return msg, nil
+}
+
+// AutoMigrate creates or updates the outbox table, an empty table uses DefaultTable.
+//
+// The index on status and availability that the relay polls is named after the table, since index names are
+// unique per schema in some databases.
+func AutoMigrate(db *gorm.DB, table string) error {
+	if table == "" {
+		table = DefaultTable
+	}
+
+	err := db.Table(table).AutoMigrate(&Event{})
+	if err != nil {
+		return err
+	}
+
+	name := table
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	index := fmt.Sprintf("idx_%s_status_available", name)
+
+	if db.Table(table).Migrator().HasIndex(&Event{}, index) {
+		return nil
+	}
+
+	err = db.Exec("CREATE INDEX ? ON ? (?, ?)",
+		clause.Column{Name: index}, clause.Table{Name: table}, clause.Column{Name: "status"}, clause.Column{Name: "available_at"},
+	).Error
+	if err != nil {
+		return fmt.Errorf("failed to create outbox index: %v", err)
+	}
+
+	return nil
+}
+
+// Write stores messages in the outbox table.
+//
+// It uses the transaction in ctx started by dbutil.WithTx, or db which should be the transaction that writes
+// the business data, so that the messages are published if and only if the transaction commits.
+func Write(ctx context.Context, db *gorm.DB, messages ...*Message) error {
+	return WriteTable(ctx, db, DefaultTable, messages...)
+}
+
+// WriteTable works like Write using a custom outbox table
+func WriteTable(ctx context.Context, db *gorm.DB, table string, messages ...*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	events := make([]*Event, 0, len(messages))
+	for _, msg := range messages {
+		switch {
+		case msg == nil:
+			return errors.New("nil outbox message not allowed")
+		case msg.Topic == "":
+			return errors.New("missing outbox message topic")
+		}
+
+		event := &Event{
+			Topic:       msg.Topic,
+			Key:         msg.Key,
+			Payload:     msg.Payload,
+			Status:      StatusPending,
+			AvailableAt: now,
+		}
+
+		if len(msg.Headers) > 0 {
+			bs, err := json.Marshal(msg.Headers)
+			if err != nil {
+				return fmt.Errorf("failed to marshal outbox message headers: %v", err)
+			}
+			event.Headers = string(bs)
+		}
+
+		events = append(events, event)
+	}
+
+	err := dbutil.DB(ctx, db).Table(table).Create(&events).Error
+	if err != nil {
+		return fmt.Errorf("failed to write outbox events: %v", err)
+	}
+
+	return nil
+}
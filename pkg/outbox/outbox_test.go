@@ -0,0 +1,104 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gidyon/gomicro/utils/dbutil"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open("file:outbox?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := AutoMigrate(db, ""); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	// Messages written in a rolled back transaction are never published
+	_ = dbutil.WithTx(ctx, db, func(ctx context.Context, tx *gorm.DB) error {
+		if err := Write(ctx, db, &Message{Topic: "rolled.back"}); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		return errors.New("rollback")
+	})
+
+	err = dbutil.WithTx(ctx, db, func(ctx context.Context, tx *gorm.DB) error {
+		return Write(ctx, db,
+			&Message{Topic: "ok", Key: "1", Payload: []byte("a"), Headers: map[string]string{"h": "v"}},
+			&Message{Topic: "fail", Key: "2"},
+		)
+	})
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	var published, deadLettered []*Message
+
+	publisher := PublisherFunc(func(ctx context.Context, msg *Message) error {
+		if msg.Topic == "fail" {
+			return errors.New("broker unavailable")
+		}
+		published = append(published, msg)
+		return nil
+	})
+
+	relay, err := NewRelay(db, publisher, &RelayOptions{
+		MaxAttempts:    2,
+		InitialBackoff: time.Nanosecond,
+		DeadLetter: PublisherFunc(func(ctx context.Context, msg *Message) error {
+			deadLettered = append(deadLettered, msg)
+			return nil
+		}),
+	})
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := relay.RelayOnce(ctx); err != nil {
+			t.Fatalf("failed to relay: %v", err)
+		}
+	}
+
+	if len(published) != 1 || published[0].Headers["h"] != "v" || string(published[0].Payload) != "a" {
+		t.Errorf("published = %+v, want the ok message", published)
+	}
+	if len(deadLettered) != 1 || deadLettered[0].Topic != "fail" {
+		t.Errorf("dead lettered = %+v, want the fail message", deadLettered)
+	}
+
+	var counts []struct {
+		Status Status
+		Count  int
+	}
+	db.Model(&Event{}).Select("status, count(*) AS count").Group("status").Order("status").Scan(&counts)
+	if len(counts) != 2 || counts[0].Status != StatusDead || counts[1].Status != StatusDone {
+		t.Errorf("event statuses = %+v, want one dead and one done", counts)
+	}
+}
+
+func TestAutoMigrate(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:outbox_migrate?mode=memory"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+
+	// Index names are per table so several outboxes can share a schema, migrating again is a no-op
+	for _, table := range []string{"orders_outbox", "payments_outbox", "orders_outbox"} {
+		if err := AutoMigrate(db, table); err != nil {
+			t.Fatalf("failed to migrate %s: %v", table, err)
+		}
+		if !db.Table(table).Migrator().HasIndex(&Event{}, "idx_"+table+"_status_available") {
+			t.Fatalf("missing status index on %s", table)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gidyon/gomicro/utils/dbutil"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Publisher publishes outbox messages to a broker
+type Publisher interface {
+	Publish(ctx context.Context, msg *Message) error
+}
+
+// PublisherFunc is a function that implements Publisher
+type PublisherFunc func(ctx context.Context, msg *Message) error
+
+// Publish calls fn(ctx, msg)
+func (fn PublisherFunc) Publish(ctx context.Context, msg *Message) error {
+	return fn(ctx, msg)
+}
+
+// RelayOptions contains options for creating a Relay
+type RelayOptions struct {
+	// Table stores the outbox events, defaults to DefaultTable
+	Table string
+	// BatchSize is the maximum number of events published per poll, defaults to 100
+	BatchSize int
+	// PollInterval is the wait between polls when the outbox is empty, defaults to 1 second
+	PollInterval time.Duration
+	// MaxAttempts is how many times publishing is attempted before the event is dead-lettered, defaults to 10
+	MaxAttempts int
+	// InitialBackoff is the wait before retrying a failed event, it doubles on every attempt, defaults to 1 second
+	InitialBackoff time.Duration
+	// MaxBackoff limits the wait before retrying a failed event, defaults to 5 minutes
+	MaxBackoff time.Duration
+	// PublishTimeout limits how long publishing an event can take, defaults to 10 seconds
+	PublishTimeout time.Duration
+	// DeadLetter optionally receives events that exhausted their attempts
+	DeadLetter Publisher
+	// Logger logs failures, no logging is done when nil
+	Logger grpclog.LoggerV2
+}
+
+// Relay publishes events written to the outbox table.
+//
+// Concurrent relays lock their batches with SELECT ... FOR UPDATE SKIP LOCKED so that each event is
+// published by one relay at a time. Delivery is at least once, consumers should deduplicate using the event ID.
+type Relay struct {
+	db        *gorm.DB
+	publisher Publisher
+	opt       RelayOptions
+}
+
+// NewRelay creates a relay that publishes outbox events in db using publisher
+func NewRelay(db *gorm.DB, publisher Publisher, opt *RelayOptions) (*Relay, error) {
+	switch {
+	case db == nil:
+		return nil, errors.New("nil db not allowed")
+	case publisher == nil:
+		return nil, errors.New("nil publisher not allowed")
+	}
+
+	r := &Relay{db: db, publisher: publisher}
+	if opt != nil {
+		r.opt = *opt
+	}
+	if r.opt.Table == "" {
+		r.opt.Table = DefaultTable
+	}
+	if r.opt.BatchSize <= 0 {
+		r.opt.BatchSize = 100
+	}
+	if r.opt.PollInterval <= 0 {
+		r.opt.PollInterval = time.Second
+	}
+	if r.opt.MaxAttempts <= 0 {
+		r.opt.MaxAttempts = 10
+	}
+	if r.opt.InitialBackoff <= 0 {
+		r.opt.InitialBackoff = time.Second
+	}
+	if r.opt.MaxBackoff <= 0 {
+		r.opt.MaxBackoff = 5 * time.Minute
+	}
+	if r.opt.PublishTimeout <= 0 {
+		r.opt.PublishTimeout = 10 * time.Second
+	}
+
+	return r, nil
+}
+
+// Run publishes events until ctx is cancelled, it can be added to a service with AddWorkers
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && r.opt.Logger != nil {
+			r.opt.Logger.Errorf("outbox relay failed: %v", err)
+		}
+
+		// Poll again immediately while there is a backlog
+		wait := r.opt.PollInterval
+		if err == nil && n == r.opt.BatchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayOnce publishes one batch of due events returning how many events were processed
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	var processed int
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+
+		query := tx.Table(r.opt.Table).
+			Where("status = ? AND available_at <= ?", StatusPending, now).
+			Order("id").
+			Limit(r.opt.BatchSize)
+
+		// SQLite has no row locks, writers are serialized instead
+		if tx.Dialector.Name() != dbutil.DialectSQLite {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+
+		var events []*Event
+		if err := query.Find(&events).Error; err != nil {
+			return fmt.Errorf("failed to get outbox events: %v", err)
+		}
+
+		for _, event := range events {
+			if err := r.publish(ctx, tx, event); err != nil {
+				return err
+			}
+			processed++
+		}
+
+		return nil
+	})
+
+	return processed, err
+}
+
+// publish publishes the event and records the outcome
+func (r *Relay) publish(ctx context.Context, tx *gorm.DB, event *Event) error {
+	err := r.send(ctx, r.publisher, event)
+	if err == nil {
+		now := time.Now().UTC()
+		return r.update(tx, event, map[string]interface{}{
+			"status":       StatusDone,
+			"attempts":     event.Attempts + 1,
+			"last_error":   "",
+			"published_at": &now,
+		})
+	}
+
+	attempts := event.Attempts + 1
+
+	if attempts < r.opt.MaxAttempts {
+		return r.update(tx, event, map[string]interface{}{
+			"attempts":     attempts,
+			"last_error":   err.Error(),
+			"available_at": time.Now().UTC().Add(r.backoff(attempts)),
+		})
+	}
+
+	if r.opt.Logger != nil {
+		r.opt.Logger.Warningf("outbox event %d on topic %s dead-lettered after %d attempts: %v", event.ID, event.Topic, attempts, err)
+	}
+
+	if r.opt.DeadLetter != nil {
+		if err := r.send(ctx, r.opt.DeadLetter, event); err != nil && r.opt.Logger != nil {
+			r.opt.Logger.Errorf("failed to dead-letter outbox event %d: %v", event.ID, err)
+		}
+	}
+
+	return r.update(tx, event, map[string]interface{}{
+		"status":     StatusDead,
+		"attempts":   attempts,
+		"last_error": err.Error(),
+	})
+}
+
+func (r *Relay) send(ctx context.Context, publisher Publisher, event *Event) error {
+	msg, err := event.Message()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.opt.PublishTimeout)
+	defer cancel()
+
+	return publisher.Publish(ctx, msg)
+}
+
+func (r *Relay) update(tx *gorm.DB, event *Event, updates map[string]interface{}) error {
+	err := tx.Table(r.opt.Table).Where("id = ?", event.ID).Updates(updates).Error
+	if err != nil {
+		return fmt.Errorf("failed to update outbox event %d: %v", event.ID, err)
+	}
+	return nil
+}
+
+// backoff returns the wait before the next attempt
+func (r *Relay) backoff(attempts int) time.Duration {
+	backoff := r.opt.InitialBackoff
+	for i := 1; i < attempts && backoff < r.opt.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.opt.MaxBackoff {
+		backoff = r.opt.MaxBackoff
+	}
+	return backoff
+}
+
+// Purge deletes events that were published before the given time
+func (r *Relay) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Table(r.opt.Table).
+		Where("status = ? AND published_at < ?", StatusDone, before.UTC()).
+		Delete(&Event{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to purge outbox events: %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,211 @@
+package tenant
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// DefaultColumn is the default tenant column of tenant-aware models
+const DefaultColumn = "project_id"
+
+// Plugin is a gorm plugin that scopes tenant-aware models to the tenant in the statement context.
+//
+// A model is tenant-aware when it has the tenant column. Queries, updates and deletes are filtered by the tenant
+// and creates set it, failing when a record belongs to another tenant. Operations without a tenant in the context fail
+// unless the context was created with WithAllTenants. Raw SQL is not scoped.
+type Plugin struct {
+	// Column is the tenant column, defaults to DefaultColumn
+	Column string
+}
+
+// Name returns the plugin name
+func (p *Plugin) Name() string {
+	return "gomicro:tenant"
+}
+
+// Initialize registers the plugin callbacks
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	if p.Column == "" {
+		p.Column = DefaultColumn
+	}
+
+	const name = "gomicro:tenant"
+
+	if err := db.Callback().Create().Before("gorm:create").Register(name, p.create); err != nil {
+		return err
+	}
+	if err := db.Callback().Query().Before("gorm:query").Register(name, p.scope); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register(name, p.update); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:delete").Register(name, p.scope); err != nil {
+		return err
+	}
+	return db.Callback().Row().Before("gorm:row").Register(name, p.scope)
+}
+
+// field returns the tenant field of the statement model, or nil when the model is not tenant-aware
+func (p *Plugin) field(db *gorm.DB) *schema.Field {
+	if db.Statement.Schema == nil {
+		return nil
+	}
+	return db.Statement.Schema.LookUpField(p.Column)
+}
+
+// tenant returns the tenant of the statement context, ok is false for contexts not scoped to a tenant
+func (p *Plugin) tenant(db *gorm.DB) (id string, ok bool, err error) {
+	ctx := db.Statement.Context
+	if AllTenants(ctx) {
+		return "", false, nil
+	}
+	id, ok = FromContext(ctx)
+	if !ok {
+		return "", false, ErrMissingTenant
+	}
+	return id, true, nil
+}
+
+func (p *Plugin) scope(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	field := p.field(db)
+	if field == nil {
+		return
+	}
+
+	id, ok, err := p.tenant(db)
+	switch {
+	case err != nil:
+		db.AddError(err)
+		return
+	case !ok:
+		return
+	}
+
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id},
+	}})
+}
+
+func (p *Plugin) create(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	field := p.field(db)
+	if field == nil {
+		return
+	}
+
+	id, ok, err := p.tenant(db)
+	switch {
+	case err != nil:
+		db.AddError(err)
+		return
+	case !ok:
+		return
+	}
+
+	if m, ok := db.Statement.Dest.(map[string]interface{}); ok {
+		if err := setMapTenant(field, m, id); err != nil {
+			db.AddError(err)
+		}
+		return
+	}
+
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := p.setTenant(db, field, reflect.Indirect(rv.Index(i)), id); err != nil {
+				db.AddError(err)
+				return
+			}
+		}
+	case reflect.Struct:
+		if err := p.setTenant(db, field, rv, id); err != nil {
+			db.AddError(err)
+		}
+	}
+}
+
+func (p *Plugin) update(db *gorm.DB) {
+	p.scope(db)
+
+	if db.Error != nil {
+		return
+	}
+
+	field := p.field(db)
+	if field == nil {
+		return
+	}
+
+	id, ok, _ := p.tenant(db)
+	if !ok {
+		return
+	}
+
+	// Refuse moving records to another tenant
+	var value interface{}
+	switch dest := db.Statement.Dest.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{field.DBName, field.Name} {
+			if v, ok := dest[key]; ok {
+				value = v
+			}
+		}
+	default:
+		rv := reflect.Indirect(reflect.ValueOf(dest))
+		if rv.Kind() == reflect.Struct && rv.Type() == db.Statement.Schema.ModelType {
+			v, zero := field.ValueOf(db.Statement.Context, rv)
+			switch {
+			case !zero:
+				value = v
+			case rv.CanAddr():
+				// Save writes every column, keep the record in the tenant
+				if err := field.Set(db.Statement.Context, rv, id); err != nil {
+					db.AddError(err)
+				}
+			}
+		}
+	}
+
+	if value != nil && fmt.Sprint(value) != id {
+		db.AddError(status.Errorf(codes.PermissionDenied, "cross-tenant update to tenant %v denied", value))
+	}
+}
+
+func setMapTenant(field *schema.Field, m map[string]interface{}, id string) error {
+	for _, key := range []string{field.DBName, field.Name} {
+		if v, ok := m[key]; ok {
+			if fmt.Sprint(v) != id {
+				return status.Errorf(codes.PermissionDenied, "cross-tenant create for tenant %v denied", v)
+			}
+			return nil
+		}
+	}
+	m[field.DBName] = id
+	return nil
+}
+
+func (p *Plugin) setTenant(db *gorm.DB, field *schema.Field, rv reflect.Value, id string) error {
+	ctx := db.Statement.Context
+
+	value, zero := field.ValueOf(ctx, rv)
+	if !zero && fmt.Sprint(value) != id {
+		return status.Errorf(codes.PermissionDenied, "cross-tenant create for tenant %v denied", value)
+	}
+
+	return field.Set(ctx, rv, id)
+}
@@ -0,0 +1,197 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+
+	grpcauth "github.com/gidyon/gomicro/pkg/grpc/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultHeader is the default metadata key that carries the tenant for service calls
+const DefaultHeader = "x-project-id"
+
+// ErrMissingTenant is returned when a tenant-aware operation has no tenant in the context
+var ErrMissingTenant = status.Error(codes.PermissionDenied, "missing tenant for tenant-scoped operation")
+
+type tenantKey struct{}
+
+type tenantInfo struct {
+	id  string
+	all bool
+}
+
+// WithTenant returns a context scoped to the tenant
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, &tenantInfo{id: id})
+}
+
+// WithAllTenants returns a context that is not scoped to a tenant.
+//
+// It must only be used by trusted code such as background workers or handlers that have checked the caller is a super admin,
+// the interceptors never derive it from a request.
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantKey{}, &tenantInfo{all: true})
+}
+
+// FromContext returns the tenant of the context and whether there is one
+func FromContext(ctx context.Context) (string, bool) {
+	info, ok := ctx.Value(tenantKey{}).(*tenantInfo)
+	if !ok || info.all || info.id == "" {
+		return "", false
+	}
+	return info.id, true
+}
+
+// AllTenants checks whether the context was created with WithAllTenants
+func AllTenants(ctx context.Context) bool {
+	info, ok := ctx.Value(tenantKey{}).(*tenantInfo)
+	return ok && info.all
+}
+
+// Options contains options for resolving the tenant of requests
+type Options struct {
+	// API reads the authenticated claims, authentication must run before the tenant interceptors
+	API *grpcauth.API
+	// Header carries the tenant for service calls, defaults to DefaultHeader
+	Header string
+	// TrustedGroups may act on the tenant in Header, super admins always may
+	TrustedGroups []string
+	// Required rejects requests without a tenant
+	Required bool
+	// SkipMethods are full method names that are not tenant scoped, such as health checks
+	SkipMethods []string
+}
+
+// Resolver resolves the tenant of requests from claims or a trusted header
+type Resolver struct {
+	opt  Options
+	skip map[string]bool
+}
+
+// NewResolver creates a tenant resolver
+func NewResolver(opt *Options) (*Resolver, error) {
+	switch {
+	case opt == nil:
+		return nil, errors.New("nil tenant options not allowed")
+	case opt.API == nil:
+		return nil, errors.New("missing auth api for tenant resolution")
+	}
+
+	r := &Resolver{opt: *opt, skip: make(map[string]bool, len(opt.SkipMethods))}
+	if r.opt.Header == "" {
+		r.opt.Header = DefaultHeader
+	}
+	for _, method := range opt.SkipMethods {
+		r.skip[method] = true
+	}
+
+	return r, nil
+}
+
+// Resolve returns a context scoped to the tenant of the request.
+//
+// The tenant is the ProjectID of the authenticated claims. A tenant in the trusted header is used when it is the same,
+// or when the caller is a super admin or in a trusted group; any other cross-tenant request is refused.
+func (r *Resolver) Resolve(ctx context.Context) (context.Context, error) {
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(r.opt.Header); len(vals) > 0 {
+			header = vals[0]
+		}
+	}
+
+	claims, err := r.opt.API.GetClaims(ctx)
+	if err != nil {
+		if header != "" || r.opt.Required {
+			return nil, status.Error(codes.Unauthenticated, "authentication required for tenant-scoped request")
+		}
+		return ctx, nil
+	}
+
+	id := claims.ProjectID
+
+	if header != "" && header != id {
+		if !r.trusted(claims) {
+			return nil, status.Errorf(codes.PermissionDenied, "cross-tenant access to %s denied", header)
+		}
+		id = header
+	}
+
+	if id == "" {
+		if r.opt.Required {
+			return nil, status.Error(codes.PermissionDenied, "no tenant for request")
+		}
+		return ctx, nil
+	}
+
+	return WithTenant(ctx, id), nil
+}
+
+func (r *Resolver) trusted(claims *grpcauth.Claims) bool {
+	if claims.Payload == nil {
+		return false
+	}
+	if r.opt.API.IsSuperAdmin(claims.Group) {
+		return true
+	}
+	if r.opt.API.IsGroupAllowed(claims.Group, r.opt.TrustedGroups...) {
+		return true
+	}
+	for _, role := range claims.Roles {
+		if r.opt.API.IsSuperAdmin(role) || r.opt.API.IsGroupAllowed(role, r.opt.TrustedGroups...) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryServerInterceptor resolves the tenant of unary requests into the context
+func (r *Resolver) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if r.skip[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		ctx, err := r.Resolve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor resolves the tenant of streams into the context
+func (r *Resolver) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if r.skip[info.FullMethod] {
+			return handler(srv, ss)
+		}
+		ctx, err := r.Resolve(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+// OutgoingContext adds the tenant of ctx to the outgoing metadata in the resolver header so downstream services
+// using the same header act on the same tenant
+func (r *Resolver) OutgoingContext(ctx context.Context) context.Context {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, r.opt.Header, id)
+}
@@ -0,0 +1,127 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	grpcauth "github.com/gidyon/gomicro/pkg/grpc/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type note struct {
+	ID        uint
+	ProjectID string
+	Text      string
+}
+
+func authenticated(t *testing.T, api *grpcauth.API, payload *grpcauth.Payload, header string) context.Context {
+	token, err := api.GenToken(context.Background(), payload, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	md := metadata.Pairs("authorization", "Bearer "+token)
+	if header != "" {
+		md.Set(DefaultHeader, header)
+	}
+	ctx, err := api.Authenticator(metadata.NewIncomingContext(context.Background(), md))
+	if err != nil {
+		t.Fatalf("failed to authenticate: %v", err)
+	}
+	return ctx
+}
+
+func TestTenantScoping(t *testing.T) {
+	api := grpcauth.NewAPI([]byte("tenant-test-signing-key"), "test", "test")
+	api.AddSuperAdminGroups("SUPER_ADMIN")
+
+	resolver, err := NewResolver(&Options{API: api, Required: true})
+	if err != nil {
+		t.Fatalf("failed to create resolver: %v", err)
+	}
+
+	// Cross-tenant access is refused for ordinary users
+	_, err = resolver.Resolve(authenticated(t, api, &grpcauth.Payload{ID: "1", ProjectID: "a", Group: "USER"}, "b"))
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Resolve() error = %v, want PermissionDenied", err)
+	}
+
+	ctxA, err := resolver.Resolve(authenticated(t, api, &grpcauth.Payload{ID: "1", ProjectID: "a", Group: "USER"}, ""))
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	ctxB, err := resolver.Resolve(authenticated(t, api, &grpcauth.Payload{ID: "2", ProjectID: "a", Group: "SUPER_ADMIN"}, "b"))
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if id, _ := FromContext(ctxB); id != "b" {
+		t.Fatalf("super admin tenant = %q, want b", id)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file:tenant?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := db.Use(&Plugin{}); err != nil {
+		t.Fatalf("failed to use plugin: %v", err)
+	}
+	if err := db.WithContext(WithAllTenants(context.Background())).AutoMigrate(&note{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	if err := db.WithContext(ctxA).Create(&note{Text: "a"}).Error; err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	if err := db.WithContext(ctxB).Create(&[]*note{{Text: "b"}, {Text: "b"}}).Error; err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	if err := db.WithContext(ctxA).Create(&note{ProjectID: "b"}).Error; status.Code(err) != codes.PermissionDenied {
+		t.Errorf("cross-tenant create error = %v, want PermissionDenied", err)
+	}
+	if err := db.Find(&[]*note{}).Error; err != ErrMissingTenant {
+		t.Errorf("unscoped query error = %v, want ErrMissingTenant", err)
+	}
+
+	var notes []*note
+	if err := db.WithContext(ctxA).Find(&notes).Error; err != nil || len(notes) != 1 || notes[0].ProjectID != "a" {
+		t.Errorf("tenant a notes = %v, %v", notes, err)
+	}
+
+	res := db.WithContext(ctxA).Model(&note{}).Where("id > 0").Update("text", "x")
+	if res.Error != nil || res.RowsAffected != 1 {
+		t.Errorf("tenant a update affected %d rows, err %v", res.RowsAffected, res.Error)
+	}
+	if err := db.WithContext(ctxA).Model(&note{}).Where("id = 1").Update("project_id", "b").Error; status.Code(err) != codes.PermissionDenied {
+		t.Errorf("cross-tenant update error = %v, want PermissionDenied", err)
+	}
+
+	var count int64
+	db.WithContext(WithAllTenants(context.Background())).Model(&note{}).Count(&count)
+	if count != 3 {
+		t.Errorf("all tenants count = %d, want 3", count)
+	}
+}
+
+func TestOutgoingContext(t *testing.T) {
+	resolver, err := NewResolver(&Options{API: grpcauth.NewAPI([]byte("tenant-test-signing-key"), "test", "test"), Header: "x-project"})
+	if err != nil {
+		t.Fatalf("failed to create resolver: %v", err)
+	}
+
+	md, _ := metadata.FromOutgoingContext(resolver.OutgoingContext(WithTenant(context.Background(), "a")))
+	if vals := md.Get("x-project"); len(vals) != 1 || vals[0] != "a" {
+		t.Fatalf("outgoing tenant header = %v, want [a]", vals)
+	}
+	if len(md.Get(DefaultHeader)) != 0 {
+		t.Fatalf("unexpected %s header in %v", DefaultHeader, md)
+	}
+
+	if _, ok := metadata.FromOutgoingContext(resolver.OutgoingContext(context.Background())); ok {
+		t.Fatal("expected no outgoing metadata without a tenant")
+	}
+}
@@ -3,8 +3,8 @@ package gomicro
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"os"
@@ -12,7 +12,6 @@ import (
 	"strings"
 
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/gidyon/gomicro/pkg/conn"
 
@@ -37,12 +36,29 @@ func handleErrs(errs ...error) {
 // initializes service without starting it.
 func (service *Service) init(ctx context.Context) {
 	service.initOnceFn.Do(func() {
-		handleErrs(
-			service.initGRPC(ctx),
-		)
+		// Later steps must not run when a startup hook such as a migration fails
+		if err := service.runStartupHooks(ctx); err != nil {
+			panic(err)
+		}
+		if err := service.initGRPC(ctx); err != nil {
+			panic(err)
+		}
+		if err := service.initClientConns(ctx); err != nil {
+			panic(err)
+		}
 	})
 }
 
+// runs startup hooks in order stopping at the first error
+func (service *Service) runStartupHooks(ctx context.Context) error {
+	for _, hook := range service.startupHooks {
+		if err := hook(ctx); err != nil {
+			return fmt.Errorf("startup hook failed: %v", err)
+		}
+	}
+	return nil
+}
+
 // Initialize initializes service without starting it.
 func (service *Service) Initialize(ctx context.Context) {
 	service.init(ctx)
@@ -51,7 +67,8 @@ func (service *Service) Initialize(ctx context.Context) {
 // Start starts grpc and http server to serve requests.
 func (service *Service) Start(ctx context.Context, initFn func() error) {
 	service.init(ctx)
-	handleErrs(initFn(), service.run(ctx))
+	handleErrs(initFn())
+	handleErrs(service.run(ctx))
 }
 
 // apply applies a chain of middleware in order
@@ -79,6 +96,8 @@ func (service *Service) run(ctx context.Context) error {
 			}
 		}()
 
+		service.startWorkers(ctx)
+
 		// Handles grpc gateway apis
 		service.AddEndpoint(service.options.RuntimeMuxEndpoint, service.runtimeMux)
 
@@ -102,19 +121,33 @@ func (service *Service) run(ctx context.Context) error {
 			WriteTimeout:      service.options.ServerWriteTimeout,
 		}
 
-		// Graceful shutdown of server
+		// Graceful shutdown of server, the shutdown hooks run once serving returns
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
+		defer signal.Stop(c)
+
+		stopped := make(chan struct{})
 		go func() {
-			for range c {
-				service.options.Logger.Warning("shutting down service ...")
-				service.gRPCServer.Stop()
-				log.Fatalln(httpServer.Shutdown(ctx))
+			defer close(stopped)
 
-				<-ctx.Done()
+			<-c
+			service.options.Logger.Warning("shutting down service ...")
+			service.gRPCServer.Stop()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				service.options.Logger.Errorf("failed to shutdown http server: %v", err)
 			}
 		}()
 
+		serve := func(lis net.Listener) error {
+			err := httpServer.Serve(lis)
+			if errors.Is(err, http.ErrServerClosed) {
+				// Wait for in-flight requests before running the shutdown hooks
+				<-stopped
+				return nil
+			}
+			return err
+		}
+
 		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", service.options.HttpPort))
 		if err != nil {
 			return fmt.Errorf("failed to create TCP listener for http server: %v", err)
@@ -150,7 +183,7 @@ func (service *Service) run(ctx context.Context) error {
 			}()
 
 			// Serve http insecurely
-			return httpServer.Serve(lis)
+			return serve(lis)
 		}
 
 		// Get PK for server
@@ -171,7 +204,7 @@ func (service *Service) run(ctx context.Context) error {
 		}
 
 		// Serve tls
-		return httpServer.Serve(tls.NewListener(lis, tlsConfig))
+		return serve(tls.NewListener(lis, tlsConfig))
 	}
 
 	var err error
@@ -213,14 +246,8 @@ func (service *Service) initGRPC(ctx context.Context) error {
 	)
 
 	if service.options.TLSEnabled {
-		creds, err := credentials.NewClientTLSFromFile(service.options.TlSCertFile, service.options.TLSServerName)
-		if err != nil {
-			return fmt.Errorf("failed to create tls config for %s service: %v", service.options.TLSServerName, err)
-		}
-		service.dialOptions = append(service.dialOptions, grpc.WithTransportCredentials(creds))
 		gPort = service.options.HttpPort
 	} else {
-		service.dialOptions = append(service.dialOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))
 		gPort = service.options.GrpcPort
 	}
 
@@ -256,10 +283,14 @@ func (service *Service) initGRPC(ctx context.Context) error {
 
 	// client connection to the reverse gateway
 	service.clientConn, err = conn.DialGrpcService(context.Background(), &conn.GrpcDialOptions{
-		ServiceName: "self",
-		Address:     fmt.Sprintf("localhost:%d", gPort),
-		DialOptions: service.dialOptions,
-		K8Service:   false,
+		ServiceName:   "self",
+		Address:       fmt.Sprintf("localhost:%d", gPort),
+		DialOptions:   service.dialOptions,
+		K8Service:     false,
+		Insecure:      !service.options.TLSEnabled,
+		TLSEnabled:    service.options.TLSEnabled,
+		TLSCAFile:     service.options.TlSCertFile,
+		TLSServerName: service.options.TLSServerName,
 	})
 	if err != nil {
 		return fmt.Errorf("client failed to dial to gRPC server: %v", err)
@@ -1,13 +1,238 @@
 package dbutil
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"regexp"
 	"strings"
+
+	"github.com/gidyon/gomicro/utils/errs"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc/codes"
+	"gorm.io/gorm"
+)
+
+// ErrorKind is the class of a database error
+type ErrorKind int
+
+// Database error kinds
+const (
+	KindUnknown ErrorKind = iota
+	KindNotFound
+	KindUniqueViolation
+	KindForeignKeyViolation
+	KindNotNullViolation
+	KindCheckViolation
+	// KindDeadlock includes serialization failures
+	KindDeadlock
+	KindLockTimeout
+	KindConnection
 )
 
+var kindNames = map[ErrorKind]string{
+	KindUnknown:             "unknown",
+	KindNotFound:            "not found",
+	KindUniqueViolation:     "unique violation",
+	KindForeignKeyViolation: "foreign key violation",
+	KindNotNullViolation:    "not null violation",
+	KindCheckViolation:      "check violation",
+	KindDeadlock:            "deadlock",
+	KindLockTimeout:         "lock timeout",
+	KindConnection:          "connection lost",
+}
+
+func (kind ErrorKind) String() string {
+	return kindNames[kind]
+}
+
+// DBError is a classified database error
+type DBError struct {
+	Kind ErrorKind
+	// Constraint is the violated constraint or index name when known
+	Constraint string
+	// Column is the offending column when known
+	Column string
+	Err    error
+}
+
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	mysqlKeyRe        = regexp.MustCompile(`for key '([^']+)'`)
+	mysqlConstraintRe = regexp.MustCompile("CONSTRAINT `([^`]+)`")
+	mysqlColumnRe     = regexp.MustCompile(`(?:Column|Field) '([^']+)'`)
+	mysqlCheckRe      = regexp.MustCompile(`[Cc]heck constraint '([^']+)'`)
+	sqliteColumnRe    = regexp.MustCompile(`constraint failed: ([\w.]+)`)
+)
+
+// Classify inspects MySQL, PostgreSQL and SQLite driver errors returning their kind
+// together with the constraint and column names when the driver reports them.
+//
+// It returns nil for a nil error.
+func Classify(err error) *DBError {
+	if err == nil {
+		return nil
+	}
+
+	dbErr := &DBError{Kind: KindUnknown, Err: err}
+
+	var (
+		mysqlErr  *mysql.MySQLError
+		pgErr     *pgconn.PgError
+		sqliteErr sqlite3.Error
+		netErr    net.Error
+	)
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, sql.ErrNoRows):
+		dbErr.Kind = KindNotFound
+	case errors.As(err, &mysqlErr):
+		classifyMySQL(dbErr, mysqlErr)
+	case errors.As(err, &pgErr):
+		classifyPostgres(dbErr, pgErr)
+	case errors.As(err, &sqliteErr):
+		classifySQLite(dbErr, sqliteErr)
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.Is(err, mysql.ErrInvalidConn),
+		errors.As(err, &netErr) && !errors.Is(err, context.DeadlineExceeded):
+		dbErr.Kind = KindConnection
+	case strings.Contains(strings.ToLower(err.Error()), "duplicate entry"):
+		dbErr.Kind = KindUniqueViolation
+	}
+
+	return dbErr
+}
+
+func classifyMySQL(dbErr *DBError, mysqlErr *mysql.MySQLError) {
+	submatch := func(re *regexp.Regexp) string {
+		if matches := re.FindStringSubmatch(mysqlErr.Message); matches != nil {
+			return matches[1]
+		}
+		return ""
+	}
+
+	switch mysqlErr.Number {
+	case 1062, 1586:
+		dbErr.Kind = KindUniqueViolation
+		dbErr.Constraint = submatch(mysqlKeyRe)
+	case 1216, 1217, 1451, 1452:
+		dbErr.Kind = KindForeignKeyViolation
+		dbErr.Constraint = submatch(mysqlConstraintRe)
+	case 1048, 1364:
+		dbErr.Kind = KindNotNullViolation
+		dbErr.Column = submatch(mysqlColumnRe)
+	case 3819:
+		dbErr.Kind = KindCheckViolation
+		dbErr.Constraint = submatch(mysqlCheckRe)
+	case 1213:
+		dbErr.Kind = KindDeadlock
+	case 1205:
+		dbErr.Kind = KindLockTimeout
+	case 1053, 1077, 1078, 1079, 1080, 2006, 2013:
+		dbErr.Kind = KindConnection
+	}
+}
+
+func classifyPostgres(dbErr *DBError, pgErr *pgconn.PgError) {
+	dbErr.Constraint = pgErr.ConstraintName
+	dbErr.Column = pgErr.ColumnName
+
+	switch {
+	case pgErr.Code == "23505":
+		dbErr.Kind = KindUniqueViolation
+	case pgErr.Code == "23503":
+		dbErr.Kind = KindForeignKeyViolation
+	case pgErr.Code == "23502":
+		dbErr.Kind = KindNotNullViolation
+	case pgErr.Code == "23514":
+		dbErr.Kind = KindCheckViolation
+	case pgErr.Code == "40P01", pgErr.Code == "40001":
+		dbErr.Kind = KindDeadlock
+	case pgErr.Code == "55P03":
+		dbErr.Kind = KindLockTimeout
+	case strings.HasPrefix(pgErr.Code, "08"), pgErr.Code == "57P01":
+		dbErr.Kind = KindConnection
+	}
+}
+
+func classifySQLite(dbErr *DBError, sqliteErr sqlite3.Error) {
+	column := ""
+	if matches := sqliteColumnRe.FindStringSubmatch(sqliteErr.Error()); matches != nil {
+		column = matches[1]
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		dbErr.Kind = KindUniqueViolation
+		dbErr.Column = column
+	case sqlite3.ErrConstraintForeignKey:
+		dbErr.Kind = KindForeignKeyViolation
+	case sqlite3.ErrConstraintNotNull:
+		dbErr.Kind = KindNotNullViolation
+		dbErr.Column = column
+	case sqlite3.ErrConstraintCheck:
+		dbErr.Kind = KindCheckViolation
+		dbErr.Constraint = column
+	}
+
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		dbErr.Kind = KindLockTimeout
+	}
+}
+
 // IsDuplicate checks whether error returned from db is as a result of violating unique constraint
 func IsDuplicate(err error) bool {
-	// Checks whether the error was due to similar account existing
-	return strings.Contains(
-		strings.ToLower(err.Error()), "duplicate entry",
-	)
+	return err != nil && Classify(err).Kind == KindUniqueViolation
+}
+
+// IsNotFound checks whether error returned from db is as a result of a missing record
+func IsNotFound(err error) bool {
+	return err != nil && Classify(err).Kind == KindNotFound
+}
+
+// ToStatus converts a database error to a status error with the appropriate code.
+//
+// Constraint violations are reported as field violations of the offending column or constraint.
+// The resource names the entity being operated on and is used in messages.
+func ToStatus(err error, resource string) error {
+	if err == nil {
+		return nil
+	}
+
+	dbErr := Classify(err)
+
+	field := dbErr.Column
+	if field == "" {
+		field = dbErr.Constraint
+	}
+
+	switch dbErr.Kind {
+	case KindNotFound:
+		return errs.WrapMessagef(codes.NotFound, "%s not found", resource)
+	case KindUniqueViolation:
+		return errs.FieldViolation(codes.AlreadyExists, field, resource+" already exists")
+	case KindForeignKeyViolation:
+		return errs.FieldViolation(codes.FailedPrecondition, field, resource+" references a missing or in use resource")
+	case KindNotNullViolation:
+		return errs.FieldViolation(codes.InvalidArgument, field, "missing required value")
+	case KindCheckViolation:
+		return errs.FieldViolation(codes.InvalidArgument, field, "value violates check constraint")
+	case KindDeadlock, KindLockTimeout:
+		return errs.WrapErrorWithCodeAndMsg(codes.Aborted, err, "transaction aborted")
+	case KindConnection:
+		return errs.WrapErrorWithCodeAndMsg(codes.Unavailable, err, "database unavailable")
+	default:
+		return errs.WrapErrorWithCodeAndMsg(codes.Internal, err, "database operation failed")
+	}
 }
@@ -0,0 +1,416 @@
+package dbutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gidyon/gomicro/utils/errs"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// UpdateField maps an update mask path to a column
+type UpdateField struct {
+	Column string
+	// JSON marks a column holding a JSON object, paths below the field update keys inside the object
+	JSON bool
+}
+
+// UpdateFields is the allowlist of paths that can be used in update masks, keyed by path
+type UpdateFields map[string]UpdateField
+
+var jsonKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// MaskUpdate is a validated update mask mapped to columns
+type MaskUpdate struct {
+	// Columns are updated with whole values
+	Columns []string
+	// JSONPaths are keys to set inside JSON columns, keyed by column
+	JSONPaths map[string][]*JSONPath
+}
+
+// JSONPath is a key inside a JSON column
+type JSONPath struct {
+	// Path is the update mask path
+	Path string
+	// Field is the allowlisted path of the JSON column
+	Field string
+	// Keys lead from the column to the value
+	Keys []string
+}
+
+// ParseUpdateMask validates the paths of mask against the allowlist and maps them to columns.
+//
+// The * path updates every allowlisted field. Paths below a JSON field update keys inside the column, and are
+// dropped when the whole column is updated.
+func ParseUpdateMask(mask *fieldmaskpb.FieldMask, fields UpdateFields) (*MaskUpdate, error) {
+	if mask == nil || len(mask.GetPaths()) == 0 {
+		return nil, errs.MissingField("update_mask")
+	}
+
+	var (
+		mu = &MaskUpdate{JSONPaths: map[string][]*JSONPath{}}
+		// column to whether it is updated as a whole
+		seen = map[string]bool{}
+	)
+
+	paths := mask.GetPaths()
+	for _, path := range paths {
+		if path == "*" {
+			if len(paths) > 1 {
+				return nil, errs.IncorrectVal("update_mask, * must be the only path")
+			}
+			paths = make([]string, 0, len(fields))
+			for path := range fields {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			break
+		}
+	}
+
+	var nested []*JSONPath
+
+	for _, path := range paths {
+		if field, ok := fields[path]; ok {
+			if !seen[field.Column] {
+				seen[field.Column] = true
+				mu.Columns = append(mu.Columns, field.Column)
+			}
+			continue
+		}
+
+		jp, err := jsonPath(path, fields)
+		if err != nil {
+			return nil, err
+		}
+		nested = append(nested, jp)
+	}
+
+	for _, jp := range nested {
+		column := fields[jp.Field].Column
+		if seen[column] {
+			continue
+		}
+		mu.JSONPaths[column] = append(mu.JSONPaths[column], jp)
+	}
+
+	return mu, nil
+}
+
+// jsonPath resolves a path below an allowlisted JSON field
+func jsonPath(path string, fields UpdateFields) (*JSONPath, error) {
+	segments := strings.Split(path, ".")
+	for i := len(segments) - 1; i > 0; i-- {
+		prefix := strings.Join(segments[:i], ".")
+		field, ok := fields[prefix]
+		if !ok {
+			continue
+		}
+		if !field.JSON {
+			break
+		}
+		for _, key := range segments[i:] {
+			if !jsonKeyRe.MatchString(key) {
+				return nil, errs.IncorrectVal(fmt.Sprintf("update_mask path %q", path))
+			}
+		}
+		return &JSONPath{Path: path, Field: prefix, Keys: segments[i:]}, nil
+	}
+	return nil, errs.IncorrectVal(fmt.Sprintf("update_mask path %q", path))
+}
+
+// UpdateMask updates only the fields in mask with values, which is a proto message or a gorm model.
+//
+// A gorm model is applied with Select(columns).Updates(values) when no path is below a JSON field. Otherwise,
+// and for proto messages, the update is applied from a map, so the statement must have a Model. Values of proto
+// fields are read using the allowlisted path as proto field names, timestamps are converted to time.Time, enums
+// to their names and messages, lists and maps to JSON.
+func UpdateMask(db *gorm.DB, mask *fieldmaskpb.FieldMask, fields UpdateFields, values interface{}) *gorm.DB {
+	mu, err := ParseUpdateMask(mask, fields)
+	if err != nil {
+		db.AddError(err)
+		return db
+	}
+
+	msg, isProto := values.(proto.Message)
+
+	if !isProto && len(mu.JSONPaths) == 0 {
+		return db.Select(mu.Columns).Updates(values)
+	}
+
+	var src valueSource
+	if isProto {
+		src, err = newProtoSource(msg)
+	} else {
+		src, err = newStructSource(db, values)
+	}
+	if err != nil {
+		db.AddError(err)
+		return db
+	}
+
+	columnFields := make(map[string]string, len(fields))
+	for path, field := range fields {
+		columnFields[field.Column] = path
+	}
+
+	updates := make(map[string]interface{}, len(mu.Columns)+len(mu.JSONPaths))
+
+	for _, column := range mu.Columns {
+		value, err := src.column(columnFields[column], column)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		updates[column] = value
+	}
+
+	for column, paths := range mu.JSONPaths {
+		expr, err := jsonSetExpr(db.Dialector.Name(), column, paths, src)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		updates[column] = expr
+	}
+
+	return db.Updates(updates)
+}
+
+// jsonSetExpr sets keys inside a JSON column, values are passed as JSON text.
+//
+// Objects leading to nested keys are created first, parent first, keeping their stored keys, since setting a key
+// below a missing or non-object value does nothing.
+func jsonSetExpr(dialect, column string, paths []*JSONPath, src valueSource) (clause.Expr, error) {
+	var (
+		col     = clause.Column{Name: column}
+		expr    = clause.Expr{SQL: "COALESCE(?, '{}')", Vars: []interface{}{col}}
+		created = map[string]bool{}
+	)
+
+	for _, jp := range paths {
+		for i := 1; i < len(jp.Keys); i++ {
+			keys := jp.Keys[:i]
+			name := strings.Join(keys, ".")
+			if created[name] {
+				continue
+			}
+			created[name] = true
+
+			switch dialect {
+			case DialectPostgres:
+				path := "{" + strings.Join(keys, ",") + "}"
+				expr = clause.Expr{
+					SQL:  "jsonb_set((?)::jsonb, ?::text[], CASE WHEN jsonb_typeof((?)::jsonb #> ?::text[]) = 'object' THEN (?)::jsonb #> ?::text[] ELSE '{}'::jsonb END, true)",
+					Vars: []interface{}{expr, path, col, path, col, path},
+				}
+			case DialectSQLite:
+				path := "$." + strings.Join(keys, ".")
+				expr = clause.Expr{
+					SQL:  "json_set(?, ?, CASE WHEN json_type(?, ?) = 'object' THEN json(json_extract(?, ?)) ELSE json_object() END)",
+					Vars: []interface{}{expr, path, col, path, col, path},
+				}
+			default:
+				path := "$." + strings.Join(keys, ".")
+				expr = clause.Expr{
+					SQL:  "JSON_SET(?, ?, IF(JSON_TYPE(JSON_EXTRACT(?, ?)) = 'OBJECT', JSON_EXTRACT(?, ?), JSON_OBJECT()))",
+					Vars: []interface{}{expr, path, col, path, col, path},
+				}
+			}
+		}
+	}
+
+	for _, jp := range paths {
+		value, err := src.json(jp.Field, column, jp.Keys)
+		if err != nil {
+			return expr, err
+		}
+		bs, err := json.Marshal(value)
+		if err != nil {
+			return expr, errs.FromJSONMarshal(err, jp.Path)
+		}
+
+		switch dialect {
+		case DialectPostgres:
+			expr = clause.Expr{
+				SQL:  "jsonb_set((?)::jsonb, ?::text[], ?::jsonb, true)",
+				Vars: []interface{}{expr, "{" + strings.Join(jp.Keys, ",") + "}", string(bs)},
+			}
+		case DialectSQLite:
+			expr = clause.Expr{
+				SQL:  "json_set(?, ?, json(?))",
+				Vars: []interface{}{expr, "$." + strings.Join(jp.Keys, "."), string(bs)},
+			}
+		default:
+			expr = clause.Expr{
+				SQL:  "JSON_SET(?, ?, CAST(? AS JSON))",
+				Vars: []interface{}{expr, "$." + strings.Join(jp.Keys, "."), string(bs)},
+			}
+		}
+	}
+
+	return expr, nil
+}
+
+// valueSource reads update values by allowlisted path
+type valueSource interface {
+	column(path, column string) (interface{}, error)
+	json(path, column string, keys []string) (interface{}, error)
+}
+
+type structSource struct {
+	schema *schema.Schema
+	value  reflect.Value
+}
+
+func newStructSource(db *gorm.DB, values interface{}) (*structSource, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(values); err != nil {
+		return nil, fmt.Errorf("failed to parse update values schema: %v", err)
+	}
+	return &structSource{schema: stmt.Schema, value: reflect.Indirect(reflect.ValueOf(values))}, nil
+}
+
+func (s *structSource) column(_, column string) (interface{}, error) {
+	field := s.schema.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("update values have no field for column %s", column)
+	}
+	value, _ := field.ValueOf(context.Background(), s.value)
+	return value, nil
+}
+
+func (s *structSource) json(path, column string, keys []string) (interface{}, error) {
+	value, err := s.column(path, column)
+	if err != nil {
+		return nil, err
+	}
+
+	var bs []byte
+
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	switch {
+	case !rv.IsValid():
+		return nil, nil
+	case rv.Kind() == reflect.String:
+		bs = []byte(rv.String())
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		bs = rv.Bytes()
+	default:
+		bs, err = json.Marshal(rv.Interface())
+		if err != nil {
+			return nil, errs.FromJSONMarshal(err, path)
+		}
+	}
+
+	if len(bs) == 0 {
+		return nil, nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, errs.FromJSONUnMarshal(err, path)
+	}
+
+	value, _ = lookupJSON(doc, keys)
+
+	return value, nil
+}
+
+type protoSource struct {
+	msg protoreflect.Message
+	doc map[string]interface{}
+}
+
+func newProtoSource(msg proto.Message) (*protoSource, error) {
+	bs, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return nil, errs.FromProtoMarshal(err, "update values")
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, errs.FromJSONUnMarshal(err, "update values")
+	}
+	return &protoSource{msg: msg.ProtoReflect(), doc: doc}, nil
+}
+
+func (s *protoSource) column(path, _ string) (interface{}, error) {
+	var (
+		msg      = s.msg
+		segments = strings.Split(path, ".")
+	)
+
+	for i, name := range segments {
+		fd := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("update values have no field %s", path)
+		}
+
+		if i < len(segments)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return nil, fmt.Errorf("update values field %s is not a message", path)
+			}
+			msg = msg.Get(fd).Message()
+			continue
+		}
+
+		switch {
+		case fd.IsList() || fd.IsMap():
+		case fd.Message() != nil:
+			if !msg.Has(fd) {
+				return nil, nil
+			}
+			if ts, ok := msg.Get(fd).Message().Interface().(*timestamppb.Timestamp); ok {
+				return ts.AsTime(), nil
+			}
+		case fd.Enum() != nil:
+			if ev := fd.Enum().Values().ByNumber(msg.Get(fd).Enum()); ev != nil {
+				return string(ev.Name()), nil
+			}
+			return int32(msg.Get(fd).Enum()), nil
+		default:
+			return msg.Get(fd).Interface(), nil
+		}
+
+		// Messages, lists and maps are stored as JSON
+		value, _ := lookupJSON(s.doc, segments)
+		bs, err := json.Marshal(value)
+		if err != nil {
+			return nil, errs.FromJSONMarshal(err, path)
+		}
+		return string(bs), nil
+	}
+
+	return nil, fmt.Errorf("update values have no field %s", path)
+}
+
+func (s *protoSource) json(path, _ string, keys []string) (interface{}, error) {
+	value, _ := lookupJSON(s.doc, append(strings.Split(path, "."), keys...))
+	return value, nil
+}
+
+func lookupJSON(doc interface{}, keys []string) (interface{}, bool) {
+	for _, key := range keys {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		doc, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
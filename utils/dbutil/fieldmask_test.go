@@ -0,0 +1,244 @@
+package dbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type maskProfile struct {
+	ID       uint
+	Name     string
+	Settings *string
+}
+
+var maskFields = UpdateFields{
+	"name":     {Column: "name"},
+	"settings": {Column: "settings", JSON: true},
+}
+
+func mask(paths ...string) *fieldmaskpb.FieldMask {
+	return &fieldmaskpb.FieldMask{Paths: paths}
+}
+
+func TestParseUpdateMask(t *testing.T) {
+	tests := []struct {
+		name    string
+		mask    *fieldmaskpb.FieldMask
+		columns []string
+		paths   map[string][]string
+		invalid bool
+	}{
+		{name: "columns", mask: mask("settings", "name"), columns: []string{"settings", "name"}},
+		{name: "all", mask: mask("*"), columns: []string{"name", "settings"}},
+		{name: "nested", mask: mask("name", "settings.theme.color", "settings.lang"), columns: []string{"name"},
+			paths: map[string][]string{"settings": {"settings.theme.color", "settings.lang"}}},
+		{name: "nested in updated column", mask: mask("settings.theme", "settings"), columns: []string{"settings"}},
+		{name: "missing", mask: mask(), invalid: true},
+		{name: "unknown", mask: mask("email"), invalid: true},
+		{name: "unknown nested", mask: mask("name.first"), invalid: true},
+		{name: "bad key", mask: mask("settings.theme-color"), invalid: true},
+		{name: "all with others", mask: mask("*", "name"), invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu, err := ParseUpdateMask(tt.mask, maskFields)
+			if tt.invalid {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Fatalf("expected invalid argument got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mu.Columns, tt.columns) {
+				t.Errorf("expected columns %v got %v", tt.columns, mu.Columns)
+			}
+			paths := map[string][]string{}
+			for column, jps := range mu.JSONPaths {
+				for _, jp := range jps {
+					paths[column] = append(paths[column], jp.Path)
+				}
+			}
+			if len(paths) != len(tt.paths) || (len(paths) > 0 && !reflect.DeepEqual(paths, tt.paths)) {
+				t.Errorf("expected json paths %v got %v", tt.paths, paths)
+			}
+		})
+	}
+}
+
+func TestUpdateMaskSQL(t *testing.T) {
+	tests := []struct {
+		dialect string
+		sql     string
+		vars    []interface{}
+	}{
+		{
+			dialect: DialectPostgres,
+			sql: `UPDATE "mask_profiles" SET "name"=$1,"settings"=jsonb_set((jsonb_set((jsonb_set((COALESCE("settings", '{}'))::jsonb, $2::text[], ` +
+				`CASE WHEN jsonb_typeof(("settings")::jsonb #> $3::text[]) = 'object' THEN ("settings")::jsonb #> $4::text[] ELSE '{}'::jsonb END, true))::jsonb, ` +
+				`$5::text[], $6::jsonb, true))::jsonb, $7::text[], $8::jsonb, true) WHERE "id" = $9`,
+			vars: []interface{}{"new", "{theme}", "{theme}", "{theme}", "{theme,color}", `"red"`, "{theme,font}", `"mono"`, uint(1)},
+		},
+		{
+			dialect: DialectMySQL,
+			sql: "UPDATE `mask_profiles` SET `name`=?,`settings`=JSON_SET(JSON_SET(JSON_SET(COALESCE(`settings`, '{}'), ?, " +
+				"IF(JSON_TYPE(JSON_EXTRACT(`settings`, ?)) = 'OBJECT', JSON_EXTRACT(`settings`, ?), JSON_OBJECT())), " +
+				"?, CAST(? AS JSON)), ?, CAST(? AS JSON)) WHERE `id` = ?",
+			vars: []interface{}{"new", "$.theme", "$.theme", "$.theme", "$.theme.color", `"red"`, "$.theme.font", `"mono"`, uint(1)},
+		},
+		{
+			dialect: DialectSQLite,
+			sql: "UPDATE `mask_profiles` SET `name`=?,`settings`=json_set(json_set(json_set(COALESCE(`settings`, '{}'), ?, " +
+				"CASE WHEN json_type(`settings`, ?) = 'object' THEN json(json_extract(`settings`, ?)) ELSE json_object() END), " +
+				"?, json(?)), ?, json(?)) WHERE `id` = ?",
+			vars: []interface{}{"new", "$.theme", "$.theme", "$.theme", "$.theme.color", `"red"`, "$.theme.font", `"mono"`, uint(1)},
+		},
+	}
+
+	settings := `{"theme":{"color":"red","font":"mono"}}`
+
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			db := dryRunMaskDB(t, tt.dialect)
+
+			stmt := UpdateMask(db.Model(&maskProfile{ID: 1}), mask("name", "settings.theme.color", "settings.theme.font"), maskFields,
+				&maskProfile{Name: "new", Settings: &settings}).Statement
+			if stmt.Error != nil {
+				t.Fatal(stmt.Error)
+			}
+			if sql := stmt.SQL.String(); sql != tt.sql {
+				t.Errorf("unexpected sql:\n got %s\nwant %s", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(stmt.Vars, tt.vars) {
+				t.Errorf("expected vars %v got %v", tt.vars, stmt.Vars)
+			}
+
+			// Unknown paths fail the statement
+			err := UpdateMask(db.Model(&maskProfile{ID: 1}), mask("email"), maskFields, &maskProfile{}).Error
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("expected invalid argument got %v", err)
+			}
+		})
+	}
+}
+
+// dryRunMaskDB returns a dry run db for the dialect that does not begin transactions, which would connect
+func dryRunMaskDB(t *testing.T, dialect string) *gorm.DB {
+	t.Helper()
+	return dryRunDB(t, dialect).Session(&gorm.Session{SkipDefaultTransaction: true})
+}
+
+func openMaskDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory", t.Name())), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUpdateMaskNested(t *testing.T) {
+	db := openMaskDB(t, &maskProfile{})
+
+	stored := []string{`{"theme":"dark","lang":"en"}`, `{"theme":{"color":"blue","size":1}}`}
+	profiles := []*maskProfile{{Name: "null"}, {Name: "scalar", Settings: &stored[0]}, {Name: "object", Settings: &stored[1]}}
+	if err := db.Create(&profiles).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	settings := `{"theme":{"color":"red","font":{"family":"mono"}}}`
+	for _, profile := range profiles {
+		err := UpdateMask(db.Model(profile), mask("settings.theme.color", "settings.theme.font.family"), maskFields,
+			&maskProfile{Settings: &settings}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		"null":   `{"theme":{"color":"red","font":{"family":"mono"}}}`,
+		"scalar": `{"theme":{"color":"red","font":{"family":"mono"}},"lang":"en"}`,
+		"object": `{"theme":{"color":"red","size":1,"font":{"family":"mono"}}}`,
+	}
+	for _, profile := range profiles {
+		got := &maskProfile{}
+		if err := db.First(got, profile.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, *got.Settings, want[got.Name])
+	}
+}
+
+type maskFile struct {
+	ID         uint
+	Name       string
+	Dependency string
+	Options    string
+}
+
+func TestUpdateMaskProto(t *testing.T) {
+	db := openMaskDB(t, &maskFile{})
+
+	file := &maskFile{Name: "a.proto", Dependency: `["b.proto"]`, Options: `{"go_package":"a"}`}
+	if err := db.Create(file).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	fields := UpdateFields{
+		"name":       {Column: "name"},
+		"dependency": {Column: "dependency"},
+		"options":    {Column: "options", JSON: true},
+	}
+	values := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("ignored.proto"),
+		Dependency: []string{"b.proto", "c.proto"},
+		Options:    &descriptorpb.FileOptions{JavaPackage: proto.String("com.a")},
+	}
+
+	err := UpdateMask(db.Model(file), mask("dependency", "options.java_package"), fields, values).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &maskFile{}
+	if err := db.First(got, file.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "a.proto" {
+		t.Errorf("name outside the mask was updated to %s", got.Name)
+	}
+	assertJSON(t, got.Dependency, `["b.proto","c.proto"]`)
+	assertJSON(t, got.Options, `{"go_package":"a","java_package":"com.a"}`)
+}
+
+func assertJSON(t *testing.T, got, want string) {
+	t.Helper()
+
+	var gotDoc, wantDoc interface{}
+	if err := json.Unmarshal([]byte(got), &gotDoc); err != nil {
+		t.Fatalf("invalid json %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantDoc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotDoc, wantDoc) {
+		t.Errorf("expected %s got %s", want, got)
+	}
+}
@@ -0,0 +1,511 @@
+package dbutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gidyon/gomicro/utils/errs"
+	"google.golang.org/grpc/codes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FieldType is the type of a filterable field, which decides how literals are parsed
+type FieldType int
+
+// Filterable field types
+const (
+	FieldString FieldType = iota
+	FieldNumber
+	FieldBool
+	FieldTimestamp
+)
+
+// FilterField maps an API field name to a column
+type FilterField struct {
+	Column string
+	Type   FieldType
+}
+
+// FilterFields is the allowlist of fields that can be used in filter and order_by expressions, keyed by API field name
+type FilterFields map[string]FilterField
+
+// maxFilterDepth limits nesting of parentheses in filter expressions
+const maxFilterDepth = 32
+
+// Filter is a gorm scope that applies an AIP-160 filter, errors are added to the statement
+func Filter(filter string, fields FilterFields) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		expr, err := ParseFilter(filter, fields)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		if expr == nil {
+			return db
+		}
+		return db.Where(expr)
+	}
+}
+
+// OrderBy is a gorm scope that applies an AIP-132 order_by, errors are added to the statement
+func OrderBy(orderBy string, fields FilterFields) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		columns, err := ParseOrderBy(orderBy, fields)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		for _, sc := range columns {
+			db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: sc.Column}, Desc: sc.Desc})
+		}
+		return db
+	}
+}
+
+// ParseOrderBy parses an AIP-132 order_by such as "create_time desc, name" into sort columns.
+//
+// Fields must be in the allowlist, and the returned columns can be passed to Paginator.Page.
+func ParseOrderBy(orderBy string, fields FilterFields) ([]SortColumn, error) {
+	var (
+		columns []SortColumn
+		seen    = map[string]bool{}
+		pos     = 1
+	)
+
+	if strings.TrimSpace(orderBy) == "" {
+		return nil, nil
+	}
+
+	for _, part := range strings.Split(orderBy, ",") {
+		words := strings.Fields(part)
+		start := pos + utf8.RuneCountInString(part) - utf8.RuneCountInString(strings.TrimLeftFunc(part, unicode.IsSpace))
+		pos += utf8.RuneCountInString(part) + 1
+
+		if len(words) == 0 || len(words) > 2 {
+			return nil, orderByError(start, "expected field name optionally followed by asc or desc")
+		}
+
+		field, ok := fields[words[0]]
+		if !ok {
+			return nil, orderByError(start, fmt.Sprintf("unknown field %q", words[0]))
+		}
+		if seen[words[0]] {
+			return nil, orderByError(start, fmt.Sprintf("duplicate field %q", words[0]))
+		}
+		seen[words[0]] = true
+
+		sc := SortColumn{Column: field.Column}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				sc.Desc = true
+			default:
+				return nil, orderByError(start, fmt.Sprintf("unexpected %q, expected asc or desc", words[1]))
+			}
+		}
+
+		columns = append(columns, sc)
+	}
+
+	return columns, nil
+}
+
+func orderByError(pos int, msg string) error {
+	return errs.FieldViolation(codes.InvalidArgument, "order_by", fmt.Sprintf("%s at position %d", msg, pos))
+}
+
+// ParseFilter parses an AIP-160 filter into a parameterized gorm expression, it returns nil for an empty filter.
+//
+// It supports comparisons with =, !=, <, <=, > and >=, the : has operator, AND, OR, NOT or - negation,
+// implicit AND between restrictions and parentheses. Note that OR binds tighter than AND as in AIP-160.
+// String values may be quoted and * matches any characters in = and != comparisons, field:* checks that
+// the field is set and field:value checks that a string field contains the value. Timestamps are quoted RFC 3339
+// strings. Only fields in the allowlist can be used.
+func ParseFilter(filter string, fields FilterFields) (clause.Expression, error) {
+	tokens, err := lexFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &filterParser{tokens: tokens, fields: fields}
+
+	expr, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, filterError(tok.pos, fmt.Sprintf("unexpected %q", tok.text))
+	}
+
+	return clause.Expr{SQL: expr.sql, Vars: expr.vars}, nil
+}
+
+func filterError(pos int, msg string) error {
+	return errs.FieldViolation(codes.InvalidArgument, "filter", fmt.Sprintf("%s at position %d", msg, pos))
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenText
+	tokenString
+	tokenComparator
+	tokenLParen
+	tokenRParen
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func isFilterTextRune(r rune) bool {
+	if unicode.IsSpace(r) {
+		return false
+	}
+	switch r {
+	case '(', ')', '=', '!', '<', '>', ':', '"', '\'', ',':
+		return false
+	}
+	return true
+}
+
+// lexFilter splits the filter into tokens, positions are 1-based character offsets
+func lexFilter(filter string) ([]filterToken, error) {
+	var (
+		tokens []filterToken
+		runes  = []rune(filter)
+	)
+
+	for i := 0; i < len(runes); {
+		r, pos := runes[i], i+1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{kind: tokenLParen, text: "(", pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{kind: tokenRParen, text: ")", pos: pos})
+			i++
+		case r == '=' || r == ':':
+			tokens = append(tokens, filterToken{kind: tokenComparator, text: string(r), pos: pos})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, filterError(pos, `unexpected "!", expected "!="`)
+			}
+			tokens = append(tokens, filterToken{kind: tokenComparator, text: op, pos: pos})
+			i += len(op)
+		case r == '"' || r == '\'':
+			var (
+				sb     strings.Builder
+				closed bool
+			)
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					sb.WriteRune(runes[i])
+					continue
+				}
+				if runes[i] == r {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+			}
+			if !closed {
+				return nil, filterError(pos, "unterminated string")
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: sb.String(), pos: pos})
+		case isFilterTextRune(r):
+			start := i
+			for i < len(runes) && isFilterTextRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenText, text: string(runes[start:i]), pos: pos})
+		default:
+			return nil, filterError(pos, fmt.Sprintf("unexpected %q", string(r)))
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	fields FilterFields
+}
+
+type filterExpr struct {
+	sql  string
+	vars []interface{}
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.pos >= len(p.tokens) {
+		end := 1
+		if len(p.tokens) > 0 {
+			last := p.tokens[len(p.tokens)-1]
+			end = last.pos + utf8.RuneCountInString(last.text)
+		}
+		return filterToken{kind: tokenEOF, text: "end of filter", pos: end}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.peek()
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) keyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokenText && tok.text == word
+}
+
+// expression: sequence {AND sequence}
+func (p *filterParser) expression(depth int) (*filterExpr, error) {
+	if depth > maxFilterDepth {
+		return nil, filterError(p.peek().pos, "filter is nested too deeply")
+	}
+
+	var exprs []*filterExpr
+	for {
+		expr, err := p.sequence(depth)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if !p.keyword("AND") {
+			return joinFilterExprs(exprs, " AND "), nil
+		}
+		p.next()
+	}
+}
+
+// sequence: factor {factor}, restrictions separated by whitespace are joined with AND
+func (p *filterParser) sequence(depth int) (*filterExpr, error) {
+	var exprs []*filterExpr
+	for {
+		expr, err := p.factor(depth)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		tok := p.peek()
+		if tok.kind == tokenEOF || tok.kind == tokenRParen || p.keyword("AND") {
+			return joinFilterExprs(exprs, " AND "), nil
+		}
+	}
+}
+
+// factor: term {OR term}
+func (p *filterParser) factor(depth int) (*filterExpr, error) {
+	var exprs []*filterExpr
+	for {
+		expr, err := p.term(depth)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if !p.keyword("OR") {
+			return joinFilterExprs(exprs, " OR "), nil
+		}
+		p.next()
+	}
+}
+
+// term: [NOT | -] simple
+func (p *filterParser) term(depth int) (*filterExpr, error) {
+	tok := p.peek()
+
+	negate := false
+	switch {
+	case p.keyword("NOT"):
+		p.next()
+		negate = true
+	case tok.kind == tokenText && strings.HasPrefix(tok.text, "-") && len(tok.text) > 1:
+		p.tokens[p.pos].text = tok.text[1:]
+		p.tokens[p.pos].pos++
+		negate = true
+	}
+
+	expr, err := p.simple(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	if negate {
+		expr.sql = "NOT " + expr.sql
+	}
+
+	return expr, nil
+}
+
+// simple: restriction | "(" expression ")"
+func (p *filterParser) simple(depth int) (*filterExpr, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenLParen:
+		expr, err := p.expression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, filterError(closing.pos, fmt.Sprintf(`unexpected %q, expected ")"`, closing.text))
+		}
+		expr.sql = "(" + expr.sql + ")"
+		return expr, nil
+	case tokenText:
+		switch tok.text {
+		case "AND", "OR", "NOT":
+			return nil, filterError(tok.pos, fmt.Sprintf("unexpected %s", tok.text))
+		}
+		return p.restriction(tok)
+	default:
+		return nil, filterError(tok.pos, fmt.Sprintf("unexpected %q, expected field name", tok.text))
+	}
+}
+
+// restriction: comparable comparator arg
+func (p *filterParser) restriction(name filterToken) (*filterExpr, error) {
+	field, ok := p.fields[name.text]
+	if !ok {
+		return nil, filterError(name.pos, fmt.Sprintf("unknown field %q", name.text))
+	}
+
+	op := p.next()
+	if op.kind != tokenComparator {
+		return nil, filterError(op.pos, fmt.Sprintf("unexpected %q, expected comparator after %s", op.text, name.text))
+	}
+
+	arg := p.next()
+	if arg.kind != tokenText && arg.kind != tokenString {
+		return nil, filterError(arg.pos, fmt.Sprintf("unexpected %q, expected value", arg.text))
+	}
+
+	column := clause.Column{Name: field.Column}
+
+	// Presence
+	if op.text == ":" && arg.kind == tokenText && arg.text == "*" {
+		if field.Type == FieldString {
+			return &filterExpr{sql: "(? IS NOT NULL AND ? <> '')", vars: []interface{}{column, column}}, nil
+		}
+		return &filterExpr{sql: "? IS NOT NULL", vars: []interface{}{column}}, nil
+	}
+
+	value, err := filterValue(field.Type, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch field.Type {
+	case FieldString:
+		s := value.(string)
+		switch {
+		case op.text == ":":
+			return &filterExpr{sql: "? LIKE ? ESCAPE '!'", vars: []interface{}{column, "%" + escapeLike(s) + "%"}}, nil
+		case (op.text == "=" || op.text == "!=") && strings.Contains(s, "*"):
+			not := ""
+			if op.text == "!=" {
+				not = "NOT "
+			}
+			pattern := strings.ReplaceAll(escapeLike(s), "*", "%")
+			return &filterExpr{sql: "? " + not + "LIKE ? ESCAPE '!'", vars: []interface{}{column, pattern}}, nil
+		}
+	case FieldBool:
+		switch op.text {
+		case "=", "!=", ":":
+		default:
+			return nil, filterError(op.pos, fmt.Sprintf("comparator %s not allowed on boolean field %s", op.text, name.text))
+		}
+	}
+
+	sqlOp := op.text
+	switch sqlOp {
+	case ":":
+		sqlOp = "="
+	case "!=":
+		sqlOp = "<>"
+	}
+
+	return &filterExpr{sql: "? " + sqlOp + " ?", vars: []interface{}{column, value}}, nil
+}
+
+func filterValue(typ FieldType, arg filterToken) (interface{}, error) {
+	switch typ {
+	case FieldNumber:
+		if i, err := strconv.ParseInt(arg.text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(arg.text, 64)
+		if err != nil {
+			return nil, filterError(arg.pos, fmt.Sprintf("invalid number %q", arg.text))
+		}
+		return f, nil
+	case FieldBool:
+		switch arg.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		default:
+			return nil, filterError(arg.pos, fmt.Sprintf("invalid boolean %q, expected true or false", arg.text))
+		}
+	case FieldTimestamp:
+		t, err := time.Parse(time.RFC3339Nano, arg.text)
+		if err != nil {
+			return nil, filterError(arg.pos, fmt.Sprintf("invalid timestamp %q, expected RFC 3339", arg.text))
+		}
+		return t.UTC(), nil
+	default:
+		return arg.text, nil
+	}
+}
+
+// escapeLike escapes LIKE wildcards using ! as the escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+func joinFilterExprs(exprs []*filterExpr, sep string) *filterExpr {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	joined := &filterExpr{}
+	parts := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		parts = append(parts, expr.sql)
+		joined.vars = append(joined.vars, expr.vars...)
+	}
+	joined.sql = "(" + strings.Join(parts, sep) + ")"
+	return joined
+}
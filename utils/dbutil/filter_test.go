@@ -0,0 +1,85 @@
+package dbutil
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestParseFilter(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+
+	fields := FilterFields{
+		"name":        {Column: "name"},
+		"age":         {Column: "age", Type: FieldNumber},
+		"active":      {Column: "is_active", Type: FieldBool},
+		"create_time": {Column: "created_at", Type: FieldTimestamp},
+	}
+
+	tests := []struct {
+		name    string
+		filter  string
+		where   string
+		vars    int
+		wantErr string
+	}{
+		{name: "empty", filter: "  "},
+		{name: "comparison", filter: `age >= 18`, where: "`age` >= ?", vars: 1},
+		{name: "or binds tighter", filter: `active = true AND age < 5 OR name = "bob"`, where: "(`is_active` = ? AND (`age` < ? OR `name` = ?))", vars: 3},
+		{name: "implicit and", filter: `-name:* (age != 3)`, where: "(NOT (`name` IS NOT NULL AND `name` <> '') AND (`age` <> ?))", vars: 1},
+		{name: "wildcard", filter: `name = "jo*"`, where: "`name` LIKE ? ESCAPE '!'", vars: 1},
+		{name: "timestamp", filter: `NOT create_time > "2021-01-01T00:00:00Z"`, where: "NOT `created_at` > ?", vars: 1},
+		{name: "unknown field", filter: `age > 1 AND password = "x"`, wantErr: "unknown field \"password\" at position 13"},
+		{name: "bad number", filter: `age > ten`, wantErr: "invalid number \"ten\" at position 7"},
+		{name: "unbalanced", filter: `(age > 1`, wantErr: "expected \")\" at position 9"},
+		{name: "bad comparator", filter: `active < true`, wantErr: "not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dest []map[string]interface{}
+			stmt := db.Table("users").Scopes(Filter(tt.filter, fields)).Find(&dest)
+			if tt.wantErr != "" {
+				if stmt.Error == nil || !strings.Contains(stmt.Error.Error(), tt.wantErr) {
+					t.Fatalf("Filter() error = %v, want %q", stmt.Error, tt.wantErr)
+				}
+				return
+			}
+			if stmt.Error != nil {
+				t.Fatalf("Filter() error = %v", stmt.Error)
+			}
+			sql := stmt.Statement.SQL.String()
+			want := "SELECT * FROM `users`"
+			if tt.where != "" {
+				want += " WHERE " + tt.where
+			}
+			if sql != want {
+				t.Errorf("Filter() sql = %s, want %s", sql, want)
+			}
+			if len(stmt.Statement.Vars) != tt.vars {
+				t.Errorf("Filter() vars = %v, want %d", stmt.Statement.Vars, tt.vars)
+			}
+		})
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	fields := FilterFields{"name": {Column: "name"}, "create_time": {Column: "created_at"}}
+
+	columns, err := ParseOrderBy("create_time desc, name", fields)
+	if err != nil {
+		t.Fatalf("ParseOrderBy() error = %v", err)
+	}
+	if len(columns) != 2 || columns[0] != (SortColumn{Column: "created_at", Desc: true}) || columns[1] != (SortColumn{Column: "name"}) {
+		t.Errorf("ParseOrderBy() = %v", columns)
+	}
+
+	if _, err := ParseOrderBy("name, age asc", fields); err == nil || !strings.Contains(err.Error(), "position 7") {
+		t.Errorf("ParseOrderBy() error = %v, want unknown field at position 7", err)
+	}
+}
@@ -2,33 +2,296 @@ package dbutil
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // FullTextIndex is full text search index
 const FullTextIndex = "fts_search_index"
 
+// FullTextOptions contains options for full-text indexes and searches
+type FullTextOptions struct {
+	// Index is the name of the index, defaults to FullTextIndex
+	Index string
+	// Language is the Postgres text search configuration, defaults to simple
+	Language string
+	// Query contains options for parsing search queries
+	Query *QueryOptions
+}
+
+var languageRe = regexp.MustCompile(`^[a-zA-Z_]+$`)
+
+func (opt *FullTextOptions) withDefaults() (*FullTextOptions, error) {
+	o := FullTextOptions{}
+	if opt != nil {
+		o = *opt
+	}
+	if o.Index == "" {
+		o.Index = FullTextIndex
+	}
+	if o.Language == "" {
+		o.Language = "simple"
+	}
+	if !languageRe.MatchString(o.Language) {
+		return nil, fmt.Errorf("invalid text search language %q", o.Language)
+	}
+	return &o, nil
+}
+
+// tsvColumn is the Postgres generated tsvector column backing the index
+func (opt *FullTextOptions) tsvColumn() string {
+	return opt.Index + "_tsv"
+}
+
+// pgIndex is the Postgres GIN index on the tsvector column, prefixed with the table name since Postgres index names
+// are unique per schema. The drop name keeps the schema of the table.
+func (opt *FullTextOptions) pgIndex(tableName string) (name, dropName string) {
+	schema, table := "", tableName
+	if i := strings.LastIndex(tableName, "."); i >= 0 {
+		schema, table = tableName[:i+1], tableName[i+1:]
+	}
+	name = table + "_" + opt.Index
+	return name, schema + name
+}
+
+// ftsTable is the SQLite FTS5 table backing the index
+func (opt *FullTextOptions) ftsTable(tableName string) string {
+	return tableName + "_" + opt.Index
+}
+
 // CreateFullTextIndex creates a full-text index
 func CreateFullTextIndex(db *gorm.DB, tableName string, columns ...string) error {
-	if ok := db.Migrator().HasIndex(tableName, FullTextIndex); ok {
-		return nil
-	}
-	sqlQuery := fmt.Sprintf(
-		"CREATE FULLTEXT INDEX %s ON %s(%s)",
-		FullTextIndex, tableName, strings.Join(columns, ","),
-	)
-	err := db.Table(tableName).Exec(sqlQuery).Error
-	return err
+	return CreateFullTextIndexWithOptions(db, tableName, nil, columns...)
 }
 
 // DropFullTextIndex drops a full-text index
 func DropFullTextIndex(db *gorm.DB, tableName string) error {
-	if ok := db.Migrator().HasIndex(tableName, FullTextIndex); !ok {
+	return DropFullTextIndexWithOptions(db, tableName, nil)
+}
+
+// CreateFullTextIndexWithOptions creates a named full-text index on columns of the table.
+//
+// MySQL uses a FULLTEXT index, Postgres a generated tsvector column with a GIN index and
+// SQLite an external content FTS5 table kept in sync with triggers, which requires building with the fts5 tag.
+func CreateFullTextIndexWithOptions(db *gorm.DB, tableName string, opt *FullTextOptions, columns ...string) error {
+	opt, err := opt.withDefaults()
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("missing columns for full-text index %s", opt.Index)
+	}
+
+	if usesNamedIndex(db) && db.Migrator().HasIndex(tableName, opt.Index) {
 		return nil
 	}
-	sqlQuery := fmt.Sprintf("ALTER TABLE %s	DROP INDEX %s", tableName, FullTextIndex)
-	err := db.Table(tableName).Exec(sqlQuery).Error
-	return err
+
+	return execAll(db, createFullTextStatements(db, tableName, opt, columns))
+}
+
+// createFullTextStatements returns the statements that create the full-text index
+func createFullTextStatements(db *gorm.DB, tableName string, opt *FullTextOptions, columns []string) []string {
+	quote := db.Statement.Quote
+	quoted := make([]string, 0, len(columns))
+	for _, column := range columns {
+		quoted = append(quoted, quote(column))
+	}
+
+	var statements []string
+
+	switch db.Dialector.Name() {
+	case DialectPostgres:
+		document := make([]string, 0, len(quoted))
+		for _, column := range quoted {
+			document = append(document, fmt.Sprintf("coalesce(%s, '')", column))
+		}
+		index, _ := opt.pgIndex(tableName)
+		statements = []string{
+			fmt.Sprintf(
+				"ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s tsvector GENERATED ALWAYS AS (to_tsvector('%s', %s)) STORED",
+				quote(tableName), quote(opt.tsvColumn()), opt.Language, strings.Join(document, " || ' ' || "),
+			),
+			fmt.Sprintf(
+				"CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s)",
+				quote(index), quote(tableName), quote(opt.tsvColumn()),
+			),
+		}
+	case DialectSQLite:
+		ftsTable := opt.ftsTable(tableName)
+		cols := strings.Join(quoted, ", ")
+		newCols := "new." + strings.Join(quoted, ", new.")
+		oldCols := "old." + strings.Join(quoted, ", old.")
+		statements = []string{
+			fmt.Sprintf(
+				"CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(%s, content=%s)",
+				quote(ftsTable), cols, quote(tableName),
+			),
+			fmt.Sprintf(
+				"CREATE TRIGGER IF NOT EXISTS %s AFTER INSERT ON %s BEGIN INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s); END",
+				quote(ftsTable+"_ai"), quote(tableName), quote(ftsTable), cols, newCols,
+			),
+			fmt.Sprintf(
+				"CREATE TRIGGER IF NOT EXISTS %s AFTER DELETE ON %s BEGIN INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s); END",
+				quote(ftsTable+"_ad"), quote(tableName), quote(ftsTable), quote(ftsTable), cols, oldCols,
+			),
+			fmt.Sprintf(
+				"CREATE TRIGGER IF NOT EXISTS %s AFTER UPDATE ON %s BEGIN INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s); INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s); END",
+				quote(ftsTable+"_au"), quote(tableName), quote(ftsTable), quote(ftsTable), cols, oldCols, quote(ftsTable), cols, newCols,
+			),
+			fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", quote(ftsTable), quote(ftsTable)),
+		}
+	default:
+		statements = []string{fmt.Sprintf(
+			"CREATE FULLTEXT INDEX %s ON %s(%s)",
+			quote(opt.Index), quote(tableName), strings.Join(quoted, ", "),
+		)}
+	}
+
+	return statements
+}
+
+// DropFullTextIndexWithOptions drops a named full-text index created with CreateFullTextIndexWithOptions
+func DropFullTextIndexWithOptions(db *gorm.DB, tableName string, opt *FullTextOptions) error {
+	opt, err := opt.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	if usesNamedIndex(db) && !db.Migrator().HasIndex(tableName, opt.Index) {
+		return nil
+	}
+
+	return execAll(db, dropFullTextStatements(db, tableName, opt))
+}
+
+// dropFullTextStatements returns the statements that drop the full-text index
+func dropFullTextStatements(db *gorm.DB, tableName string, opt *FullTextOptions) []string {
+	quote := db.Statement.Quote
+
+	var statements []string
+
+	switch db.Dialector.Name() {
+	case DialectPostgres:
+		_, index := opt.pgIndex(tableName)
+		statements = []string{
+			fmt.Sprintf("DROP INDEX IF EXISTS %s", quote(index)),
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", quote(tableName), quote(opt.tsvColumn())),
+		}
+	case DialectSQLite:
+		ftsTable := opt.ftsTable(tableName)
+		statements = []string{
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s", quote(ftsTable+"_ai")),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s", quote(ftsTable+"_ad")),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s", quote(ftsTable+"_au")),
+			fmt.Sprintf("DROP TABLE IF EXISTS %s", quote(ftsTable)),
+		}
+	default:
+		statements = []string{fmt.Sprintf("DROP INDEX %s ON %s", quote(opt.Index), quote(tableName))}
+	}
+
+	return statements
+}
+
+// usesNamedIndex checks whether the dialect uses a FULLTEXT index with the index name
+func usesNamedIndex(db *gorm.DB) bool {
+	name := db.Dialector.Name()
+	return name != DialectPostgres && name != DialectSQLite
+}
+
+func execAll(db *gorm.DB, statements []string) error {
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search is a gorm scope that filters rows matching the full-text query and orders them by relevance.
+//
+// The columns must match the columns of the MySQL index, Postgres and SQLite use the index created with
+// CreateFullTextIndex instead. A query without any terms leaves the statement unchanged.
+// Ordering added before Search is kept as a tiebreaker.
+func Search(query string, columns ...string) func(*gorm.DB) *gorm.DB {
+	return SearchWithOptions(query, nil, columns...)
+}
+
+// SearchWithOptions works like Search using a named index and custom parsing options
+func SearchWithOptions(query string, opt *FullTextOptions, columns ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		opt, err := opt.withDefaults()
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+
+		dialect := db.Dialector.Name()
+
+		ftsQuery := ParseFullTextQuery(query, opt.Query).Format(dialect)
+		if ftsQuery == "" {
+			return db
+		}
+
+		tableName := db.Statement.Table
+		if tableName == "" {
+			model := db.Statement.Model
+			if model == nil {
+				model = db.Statement.Dest
+			}
+			if err := db.Statement.Parse(model); err != nil {
+				db.AddError(err)
+				return db
+			}
+			tableName = db.Statement.Table
+		}
+
+		quote := db.Statement.Quote
+
+		switch dialect {
+		case DialectPostgres:
+			tsv := quote(tableName) + "." + quote(opt.tsvColumn())
+			tsQuery := fmt.Sprintf("to_tsquery('%s', ?)", opt.Language)
+			db = db.Where(fmt.Sprintf("%s @@ %s", tsv, tsQuery), ftsQuery)
+			return orderByRelevance(db, fmt.Sprintf("ts_rank(%s, %s) DESC", tsv, tsQuery), ftsQuery)
+		case DialectSQLite:
+			ftsTable := quote(opt.ftsTable(tableName))
+			db = db.
+				Joins(fmt.Sprintf("JOIN %s ON %s.rowid = %s.rowid", ftsTable, ftsTable, quote(tableName))).
+				Where(fmt.Sprintf("%s MATCH ?", ftsTable), ftsQuery)
+			return orderByRelevance(db, ftsTable+".rank")
+		default:
+			if len(columns) == 0 {
+				db.AddError(fmt.Errorf("missing columns for full-text search"))
+				return db
+			}
+			quoted := make([]string, 0, len(columns))
+			for _, column := range columns {
+				quoted = append(quoted, quote(column))
+			}
+			match := fmt.Sprintf("MATCH(%s) AGAINST(? IN BOOLEAN MODE)", strings.Join(quoted, ", "))
+			return orderByRelevance(db.Where(match, ftsQuery), match+" DESC", ftsQuery)
+		}
+	}
+}
+
+// orderByRelevance orders the statement by the relevance expression, keeping earlier ordering as tiebreakers.
+//
+// gorm drops ORDER BY expressions when merging columns, so ordering added after Search replaces relevance ordering.
+func orderByRelevance(db *gorm.DB, sql string, vars ...interface{}) *gorm.DB {
+	if c, ok := db.Statement.Clauses["ORDER BY"]; ok {
+		if orderBy, ok := c.Expression.(clause.OrderBy); ok && orderBy.Expression == nil {
+			for _, column := range orderBy.Columns {
+				sql += ", ?"
+				if column.Desc {
+					sql += " DESC"
+				}
+				vars = append(vars, column.Column)
+			}
+		}
+	}
+
+	return db.Clauses(clause.OrderBy{Expression: clause.Expr{SQL: sql, Vars: vars, WithoutParentheses: true}})
 }
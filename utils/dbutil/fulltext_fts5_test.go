@@ -0,0 +1,61 @@
+//go:build fts5
+
+package dbutil
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type searchNote struct {
+	ID   uint
+	Text string
+}
+
+// TestSearchSQLite runs with go test -tags fts5
+func TestSearchSQLite(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := db.AutoMigrate(&searchDoc{}, &searchNote{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateFullTextIndex(db, "search_docs", "title", "body"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateFullTextIndex(db, "search_notes", "text"); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Create(&[]*searchDoc{{Title: "gophers", Body: "hello world"}, {Title: "rust", Body: "crabs"}, {Title: "hello", Body: "hello hello"}})
+	db.Create(&[]*searchNote{{Text: "hello notes"}})
+	db.Model(&searchDoc{}).Where("title = ?", "rust").Update("body", "hello crabs")
+
+	var docs []*searchDoc
+	if err := db.Scopes(Search("hello")).Find(&docs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 3 || docs[0].Title != "hello" {
+		t.Fatalf("expected 3 docs ranked by relevance, got %+v", docs)
+	}
+
+	var notes []*searchNote
+	if err := db.Scopes(Search("notes")).Find(&notes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 {
+		t.Fatalf("expected 1 note, got %d", len(notes))
+	}
+
+	if err := DropFullTextIndex(db, "search_docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Scopes(Search("notes")).Find(&notes).Error; err != nil || len(notes) != 1 {
+		t.Fatalf("expected notes index to remain, got %v", err)
+	}
+}
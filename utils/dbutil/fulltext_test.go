@@ -0,0 +1,167 @@
+package dbutil
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type searchDoc struct {
+	ID    uint
+	Title string
+	Body  string
+}
+
+func dryRunDB(t *testing.T, dialect string) *gorm.DB {
+	t.Helper()
+
+	var dialector gorm.Dialector
+	switch dialect {
+	case DialectPostgres:
+		dialector = postgres.New(postgres.Config{DSN: "host=localhost user=test dbname=test sslmode=disable"})
+	case DialectMySQL:
+		dialector = mysql.New(mysql.Config{DSN: "test@tcp(localhost:3306)/test", SkipInitializeWithVersion: true})
+	default:
+		dialector = sqlite.Open("file::memory:")
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("failed to open %s db: %v", dialect, err)
+	}
+	return db
+}
+
+func TestFullTextIndexStatements(t *testing.T) {
+	opt, err := (*FullTextOptions)(nil).withDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dialect string
+		create  []string
+		drop    []string
+	}{
+		{
+			dialect: DialectPostgres,
+			create: []string{
+				`ALTER TABLE "public"."docs" ADD COLUMN IF NOT EXISTS "fts_search_index_tsv" tsvector GENERATED ALWAYS AS (to_tsvector('simple', coalesce("title", '') || ' ' || coalesce("body", ''))) STORED`,
+				`CREATE INDEX IF NOT EXISTS "docs_fts_search_index" ON "public"."docs" USING GIN ("fts_search_index_tsv")`,
+			},
+			drop: []string{
+				`DROP INDEX IF EXISTS "public"."docs_fts_search_index"`,
+				`ALTER TABLE "public"."docs" DROP COLUMN IF EXISTS "fts_search_index_tsv"`,
+			},
+		},
+		{
+			dialect: DialectMySQL,
+			create:  []string{"CREATE FULLTEXT INDEX `fts_search_index` ON `public`.`docs`(`title`, `body`)"},
+			drop:    []string{"DROP INDEX `fts_search_index` ON `public`.`docs`"},
+		},
+		{
+			dialect: DialectSQLite,
+			create: []string{
+				"CREATE VIRTUAL TABLE IF NOT EXISTS `public`.`docs_fts_search_index` USING fts5(`title`, `body`, content=`public`.`docs`)",
+			},
+			drop: []string{
+				"DROP TRIGGER IF EXISTS `public`.`docs_fts_search_index_ai`",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			db := dryRunDB(t, tt.dialect)
+
+			create := createFullTextStatements(db, "public.docs", opt, []string{"title", "body"})
+			for i, want := range tt.create {
+				if create[i] != want {
+					t.Errorf("create statement %d:\n got %s\nwant %s", i, create[i], want)
+				}
+			}
+
+			drop := dropFullTextStatements(db, "public.docs", opt)
+			for i, want := range tt.drop {
+				if drop[i] != want {
+					t.Errorf("drop statement %d:\n got %s\nwant %s", i, drop[i], want)
+				}
+			}
+		})
+	}
+
+	// Postgres index names are unique per schema
+	db := dryRunDB(t, DialectPostgres)
+	a := createFullTextStatements(db, "posts", opt, []string{"title"})[1]
+	b := createFullTextStatements(db, "comments", opt, []string{"title"})[1]
+	if !strings.Contains(a, `"posts_fts_search_index"`) || !strings.Contains(b, `"comments_fts_search_index"`) {
+		t.Errorf("expected per table index names, got %s and %s", a, b)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		dialect string
+		sql     []string
+	}{
+		{
+			dialect: DialectPostgres,
+			sql: []string{
+				`WHERE "search_docs"."fts_search_index_tsv" @@ to_tsquery('simple', $1)`,
+				`ORDER BY ts_rank("search_docs"."fts_search_index_tsv", to_tsquery('simple', $2)) DESC`,
+			},
+		},
+		{
+			dialect: DialectMySQL,
+			sql: []string{
+				"WHERE MATCH(`title`, `body`) AGAINST(? IN BOOLEAN MODE)",
+				"ORDER BY MATCH(`title`, `body`) AGAINST(? IN BOOLEAN MODE) DESC",
+			},
+		},
+		{
+			dialect: DialectSQLite,
+			sql: []string{
+				"JOIN `search_docs_fts_search_index` ON `search_docs_fts_search_index`.rowid = `search_docs`.rowid",
+				"WHERE `search_docs_fts_search_index` MATCH ?",
+				"ORDER BY `search_docs_fts_search_index`.rank",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			db := dryRunDB(t, tt.dialect)
+
+			var docs []*searchDoc
+			stmt := db.Scopes(Search("hello world", "title", "body")).Find(&docs).Statement
+			if stmt.Error != nil {
+				t.Fatal(stmt.Error)
+			}
+
+			sql := stmt.SQL.String()
+			for _, want := range tt.sql {
+				if !strings.Contains(sql, want) {
+					t.Errorf("expected %s in %s", want, sql)
+				}
+			}
+
+			// Earlier ordering is kept as a tiebreaker
+			sql = db.Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: true}).
+				Scopes(Search("hello", "title", "body")).Find(&docs).Statement.SQL.String()
+			if !strings.HasSuffix(sql, ", "+db.Statement.Quote("id")+" DESC") {
+				t.Errorf("expected id tiebreaker, got %s", sql)
+			}
+
+			// Queries without terms leave the statement unchanged
+			sql = db.Scopes(Search("  ")).Find(&docs).Statement.SQL.String()
+			if strings.Contains(sql, "WHERE") {
+				t.Errorf("expected no filter for empty query, got %s", sql)
+			}
+		})
+	}
+}
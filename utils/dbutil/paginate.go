@@ -0,0 +1,381 @@
+package dbutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gidyon/gomicro/utils/encryption"
+	"github.com/gidyon/gomicro/utils/errs"
+	"google.golang.org/grpc/codes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Default page sizes and token lifetime used by NewPaginator
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+	DefaultPageTTL  = 24 * time.Hour
+)
+
+// Types of page token values, numbers keep their kind so that large integers round trip exactly
+const (
+	valueTypeTime   = "time"
+	valueTypeInt    = "int"
+	valueTypeUint   = "uint"
+	valueTypeFloat  = "float"
+	valueTypeString = "string"
+	valueTypeBool   = "bool"
+	valueTypeNull   = "null"
+)
+
+// SortColumn is a column in the ordering of a paginated query.
+//
+// The ordering must end with a unique column such as the primary key so that every row has a distinct position,
+// and the columns should not be nullable.
+type SortColumn struct {
+	Column string
+	Desc   bool
+}
+
+// PaginatorOptions contains options for creating a Paginator
+type PaginatorOptions struct {
+	// DefaultPageSize is used when a request has no page size, defaults to DefaultPageSize
+	DefaultPageSize int
+	// MaxPageSize is the largest page size a request can ask for, defaults to MaxPageSize
+	MaxPageSize int
+	// TTL is how long page tokens stay valid, defaults to DefaultPageTTL
+	TTL time.Duration
+}
+
+// Paginator issues and validates opaque page tokens for keyset pagination.
+//
+// Page tokens are encrypted with the encryption API so clients can neither read nor tamper with them,
+// they are bound to the filter and ordering of the query that issued them and they expire.
+type Paginator struct {
+	api encryption.API
+	opt PaginatorOptions
+}
+
+// Page is a validated page request that is applied to a query with Scope
+type Page struct {
+	// Size is the clamped page size
+	Size   int
+	order  []SortColumn
+	hash   string
+	cursor []interface{}
+}
+
+type pageToken struct {
+	Hash    string       `json:"h"`
+	Expires int64        `json:"e"`
+	Values  []pageCursor `json:"v"`
+}
+
+type pageCursor struct {
+	Type  string          `json:"t"`
+	Value json.RawMessage `json:"v"`
+}
+
+// NewPaginator creates a paginator whose page tokens are encrypted with api
+func NewPaginator(api encryption.API, opt *PaginatorOptions) (*Paginator, error) {
+	if api == nil {
+		return nil, errors.New("nil encryption api not allowed")
+	}
+
+	p := &Paginator{api: api}
+	if opt != nil {
+		p.opt = *opt
+	}
+	if p.opt.DefaultPageSize <= 0 {
+		p.opt.DefaultPageSize = DefaultPageSize
+	}
+	if p.opt.MaxPageSize <= 0 {
+		p.opt.MaxPageSize = MaxPageSize
+	}
+	if p.opt.DefaultPageSize > p.opt.MaxPageSize {
+		p.opt.DefaultPageSize = p.opt.MaxPageSize
+	}
+	if p.opt.TTL <= 0 {
+		p.opt.TTL = DefaultPageTTL
+	}
+
+	return p, nil
+}
+
+// PageSize clamps the requested page size, using the default size when it is not positive
+func (p *Paginator) PageSize(requested int32) int {
+	switch {
+	case requested <= 0:
+		return p.opt.DefaultPageSize
+	case int(requested) > p.opt.MaxPageSize:
+		return p.opt.MaxPageSize
+	default:
+		return int(requested)
+	}
+}
+
+// Page validates the page token of a list request.
+//
+// The filter is any string identifying the query filters, such as the request filter field, and must be the
+// same when the next page is requested. An empty token starts at the first page.
+func (p *Paginator) Page(token string, pageSize int32, filter string, order ...SortColumn) (*Page, error) {
+	if len(order) == 0 {
+		return nil, errs.WrapMessage(codes.Internal, "missing ordering for paginated query")
+	}
+
+	page := &Page{
+		Size:  p.PageSize(pageSize),
+		order: order,
+		hash:  queryHash(filter, order),
+	}
+
+	if token == "" {
+		return page, nil
+	}
+
+	invalid := errs.FieldViolation(codes.InvalidArgument, "page_token", "invalid page token")
+
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalid
+	}
+
+	bs, err = p.api.Decrypt(bs)
+	if err != nil {
+		return nil, invalid
+	}
+
+	pt := &pageToken{}
+	if err := json.Unmarshal(bs, pt); err != nil {
+		return nil, invalid
+	}
+
+	switch {
+	case pt.Hash != page.hash:
+		return nil, errs.FieldViolation(codes.InvalidArgument, "page_token", "page token does not match the query")
+	case time.Now().Unix() > pt.Expires:
+		return nil, errs.FieldViolation(codes.InvalidArgument, "page_token", "page token expired")
+	case len(pt.Values) != len(order):
+		return nil, invalid
+	}
+
+	page.cursor = make([]interface{}, 0, len(pt.Values))
+	for _, v := range pt.Values {
+		value, err := v.decode()
+		if err != nil {
+			return nil, invalid
+		}
+		page.cursor = append(page.cursor, value)
+	}
+
+	return page, nil
+}
+
+// Scope is a gorm scope that orders the query, starts it after the page token position and limits it.
+//
+// It fetches one row more than the page size so that Next can tell whether there is a next page.
+func (page *Page) Scope(db *gorm.DB) *gorm.DB {
+	quote := db.Statement.Quote
+
+	for _, sc := range page.order {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: sc.Column}, Desc: sc.Desc})
+	}
+
+	if len(page.cursor) > 0 {
+		// (a > ?) OR (a = ? AND b > ?) OR ...
+		var (
+			ors  = make([]string, 0, len(page.order))
+			vars = make([]interface{}, 0, len(page.order)*(len(page.order)+1)/2)
+		)
+		for i, sc := range page.order {
+			ands := make([]string, 0, i+1)
+			for j := 0; j < i; j++ {
+				ands = append(ands, quote(page.order[j].Column)+" = ?")
+				vars = append(vars, page.cursor[j])
+			}
+			op := " > ?"
+			if sc.Desc {
+				op = " < ?"
+			}
+			ands = append(ands, quote(sc.Column)+op)
+			vars = append(vars, page.cursor[i])
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+		db = db.Where(strings.Join(ors, " OR "), vars...)
+	}
+
+	return db.Limit(page.Size + 1)
+}
+
+var pageSchemas = &sync.Map{}
+
+// Next trims the extra row fetched by Scope from the slice that dest points to and returns the token of the next page.
+//
+// It returns an empty token on the last page. The values of the ordering columns are read from the last row
+// using the gorm schema of the slice element.
+func (p *Paginator) Next(db *gorm.DB, page *Page, dest interface{}) (string, error) {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return "", fmt.Errorf("paginated results must be a pointer to a slice, got %T", dest)
+	}
+	rv = rv.Elem()
+
+	if rv.Len() <= page.Size {
+		return "", nil
+	}
+
+	rv.Set(rv.Slice(0, page.Size))
+
+	last := rv.Index(page.Size - 1)
+
+	sch, err := schema.Parse(dest, pageSchemas, db.NamingStrategy)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse paginated results schema: %v", err)
+	}
+
+	values := make([]interface{}, 0, len(page.order))
+	for _, sc := range page.order {
+		column := sc.Column
+		if i := strings.LastIndexByte(column, '.'); i >= 0 {
+			column = column[i+1:]
+		}
+		field := sch.LookUpField(column)
+		if field == nil {
+			return "", fmt.Errorf("paginated results have no field for column %s", sc.Column)
+		}
+		value, _ := field.ValueOf(context.Background(), last)
+		values = append(values, value)
+	}
+
+	return p.token(page.hash, values)
+}
+
+// NextPageToken returns the token of the page that starts after the row with the given ordering column values
+func (p *Paginator) NextPageToken(page *Page, values ...interface{}) (string, error) {
+	if len(values) != len(page.order) {
+		return "", fmt.Errorf("expected %d page token values, got %d", len(page.order), len(values))
+	}
+	return p.token(page.hash, values)
+}
+
+func (p *Paginator) token(hash string, values []interface{}) (string, error) {
+	pt := &pageToken{
+		Hash:    hash,
+		Expires: time.Now().Add(p.opt.TTL).Unix(),
+		Values:  make([]pageCursor, 0, len(values)),
+	}
+
+	for _, value := range values {
+		v, err := encodeCursor(value)
+		if err != nil {
+			return "", err
+		}
+		pt.Values = append(pt.Values, v)
+	}
+
+	bs, err := json.Marshal(pt)
+	if err != nil {
+		return "", errs.FromJSONMarshal(err, "page token")
+	}
+
+	bs, err = p.api.Encrypt(bs)
+	if err != nil {
+		return "", errs.FailedToEncrypt(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+func queryHash(filter string, order []SortColumn) string {
+	h := sha256.New()
+	h.Write([]byte(filter))
+	for _, sc := range order {
+		fmt.Fprintf(h, "\x00%s:%t", sc.Column, sc.Desc)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func encodeCursor(value interface{}) (pageCursor, error) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return pageCursor{Type: valueTypeNull, Value: json.RawMessage("null")}, nil
+		}
+		rv = rv.Elem()
+	}
+
+	var typ string
+
+	switch v := rv.Interface().(type) {
+	case time.Time:
+		value, typ = v.UTC().Format(time.RFC3339Nano), valueTypeTime
+	default:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value, typ = rv.Int(), valueTypeInt
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value, typ = rv.Uint(), valueTypeUint
+		case reflect.Float32, reflect.Float64:
+			value, typ = rv.Float(), valueTypeFloat
+		case reflect.String:
+			value, typ = rv.String(), valueTypeString
+		case reflect.Bool:
+			value, typ = rv.Bool(), valueTypeBool
+		default:
+			return pageCursor{}, fmt.Errorf("unsupported page token value of type %T", v)
+		}
+	}
+
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return pageCursor{}, errs.FromJSONMarshal(err, "page token value")
+	}
+
+	return pageCursor{Type: typ, Value: bs}, nil
+}
+
+func (pc pageCursor) decode() (interface{}, error) {
+	switch pc.Type {
+	case valueTypeNull:
+		return nil, nil
+	case valueTypeTime:
+		var s string
+		if err := json.Unmarshal(pc.Value, &s); err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case valueTypeInt:
+		var i int64
+		err := json.Unmarshal(pc.Value, &i)
+		return i, err
+	case valueTypeUint:
+		var u uint64
+		err := json.Unmarshal(pc.Value, &u)
+		return u, err
+	case valueTypeFloat:
+		var f float64
+		err := json.Unmarshal(pc.Value, &f)
+		return f, err
+	case valueTypeString:
+		var s string
+		err := json.Unmarshal(pc.Value, &s)
+		return s, err
+	case valueTypeBool:
+		var b bool
+		err := json.Unmarshal(pc.Value, &b)
+		return b, err
+	default:
+		return nil, fmt.Errorf("unknown page token value type %q", pc.Type)
+	}
+}
@@ -0,0 +1,204 @@
+package dbutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gidyon/gomicro/utils/encryption"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestPaginator(t *testing.T, key string) (*Paginator, encryption.API) {
+	t.Helper()
+
+	api, err := encryption.NewAPI([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPaginator(api, &PaginatorOptions{DefaultPageSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, api
+}
+
+// sealPageToken encrypts a page token as issued by a paginator using api
+func sealPageToken(t *testing.T, api encryption.API, pt *pageToken) string {
+	t.Helper()
+
+	bs, err := json.Marshal(pt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err = api.Encrypt(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+type pageItem struct {
+	ID   uint
+	Rank int
+}
+
+func TestPaginate(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:paginate?mode=memory"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := db.AutoMigrate(&pageItem{}); err != nil {
+		t.Fatal(err)
+	}
+	items := []*pageItem{{Rank: 2}, {Rank: 1}, {Rank: 2}, {Rank: 3}, {Rank: 1}, {Rank: 2}, {Rank: 3}}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := newTestPaginator(t, "paginate-test-key-0123456789abcd")
+	order := []SortColumn{{Column: "rank", Desc: true}, {Column: "id"}}
+
+	var (
+		ids   []uint
+		token string
+		pages int
+	)
+	for {
+		page, err := p.Page(token, 0, "", order...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*pageItem
+		if err := db.Scopes(page.Scope).Find(&got).Error; err != nil {
+			t.Fatal(err)
+		}
+		token, err = p.Next(db, page, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range got {
+			ids = append(ids, item.ID)
+		}
+		pages++
+		if token == "" {
+			break
+		}
+	}
+
+	want := []uint{4, 7, 1, 3, 6, 2, 5}
+	if pages != 3 || !reflect.DeepEqual(ids, want) {
+		t.Fatalf("expected %v in 3 pages got %v in %d pages", want, ids, pages)
+	}
+}
+
+func TestPageTokenErrors(t *testing.T) {
+	p, api := newTestPaginator(t, "paginate-test-key-0123456789abcd")
+	other, _ := newTestPaginator(t, "another-test-key-0123456789abcde")
+
+	order := []SortColumn{{Column: "rank", Desc: true}, {Column: "id"}}
+	page, err := p.Page("", 0, "rank > 1", order...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.NextPageToken(page, 2, uint(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs[len(bs)-1] ^= 1
+	tampered := base64.RawURLEncoding.EncodeToString(bs)
+
+	hash := queryHash("rank > 1", order)
+	values := []pageCursor{{Type: valueTypeInt, Value: json.RawMessage("2")}, {Type: valueTypeUint, Value: json.RawMessage("3")}}
+
+	tests := []struct {
+		name   string
+		p      *Paginator
+		token  string
+		filter string
+		order  []SortColumn
+		msg    string
+	}{
+		{name: "valid", p: p, token: token, filter: "rank > 1", order: order},
+		{name: "not base64", p: p, token: "not a token!", filter: "rank > 1", order: order, msg: "invalid page token"},
+		{name: "tampered", p: p, token: tampered, filter: "rank > 1", order: order, msg: "invalid page token"},
+		{name: "wrong key", p: other, token: token, filter: "rank > 1", order: order, msg: "invalid page token"},
+		{name: "other filter", p: p, token: token, filter: "rank > 2", order: order, msg: "does not match the query"},
+		{name: "other order", p: p, token: token, filter: "rank > 1", order: []SortColumn{{Column: "rank"}, {Column: "id"}},
+			msg: "does not match the query"},
+		{name: "expired", p: p, token: sealPageToken(t, api, &pageToken{Hash: hash, Expires: time.Now().Add(-time.Minute).Unix(), Values: values}),
+			filter: "rank > 1", order: order, msg: "page token expired"},
+		{name: "missing values", p: p, token: sealPageToken(t, api, &pageToken{Hash: hash, Expires: time.Now().Add(time.Minute).Unix(), Values: values[:1]}),
+			filter: "rank > 1", order: order, msg: "invalid page token"},
+		{name: "unknown type", p: p, token: sealPageToken(t, api, &pageToken{Hash: hash, Expires: time.Now().Add(time.Minute).Unix(),
+			Values: []pageCursor{values[0], {Type: "decimal", Value: json.RawMessage("3")}}}), filter: "rank > 1", order: order, msg: "invalid page token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.p.Page(tt.token, 0, tt.filter, tt.order...)
+			if tt.msg == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if status.Code(err) != codes.InvalidArgument || !strings.Contains(status.Convert(err).Message(), tt.msg) {
+				t.Fatalf("expected invalid argument %q got %v", tt.msg, err)
+			}
+		})
+	}
+}
+
+func TestPageTokenValues(t *testing.T) {
+	p, _ := newTestPaginator(t, "paginate-test-key-0123456789abcd")
+
+	var (
+		count   = int32(7)
+		created = time.Date(2022, 3, 4, 5, 6, 7, 8, time.FixedZone("EAT", 3*60*60))
+		values  = []interface{}{int64(math.MinInt64), uint64(math.MaxUint64), uint8(255), 1.5, float32(0.25), "a\x00b", true, created, &count, (*int)(nil)}
+		want    = []interface{}{int64(math.MinInt64), uint64(math.MaxUint64), uint64(255), 1.5, 0.25, "a\x00b", true, created.UTC(), int64(7), nil}
+	)
+
+	order := make([]SortColumn, len(values))
+	for i := range order {
+		order[i] = SortColumn{Column: string(rune('a' + i))}
+	}
+
+	page, err := p.Page("", 0, "", order...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.NextPageToken(page, values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = p.Page(token, 0, "", order...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(page.cursor, want) {
+		t.Fatalf("expected cursor %#v got %#v", want, page.cursor)
+	}
+
+	if _, err := p.NextPageToken(page, make([]interface{}, len(values)-1)...); err == nil {
+		t.Fatal("expected error for missing values")
+	}
+	if _, err := p.NextPageToken(page, append([]interface{}{struct{}{}}, values[1:]...)...); err == nil {
+		t.Fatal("expected error for unsupported value")
+	}
+}
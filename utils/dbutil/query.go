@@ -2,19 +2,235 @@ package dbutil
 
 import (
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-// ParseQuery parses a random query to a full-text query
+// Query dialects matching gorm dialector names
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// Operator is how a clause of a full-text query participates in matching
+type Operator int
+
+// Full-text query operators
+const (
+	Optional Operator = iota
+	Required
+	Excluded
+)
+
+// QueryTerm is a word or a quoted phrase in a full-text query
+type QueryTerm struct {
+	Words  []string
+	Phrase bool
+	Prefix bool
+}
+
+// QueryClause is a term or a group of alternative terms joined with OR
+type QueryClause struct {
+	Operator Operator
+	Terms    []*QueryTerm
+}
+
+// FullTextQuery is a parsed boolean full-text query
+type FullTextQuery struct {
+	Clauses []*QueryClause
+}
+
+// QueryOptions contains options for parsing full-text queries
+type QueryOptions struct {
+	// StopWords are dropped from the query, phrases are kept as is
+	StopWords []string
+	// MinTokenLength drops words with fewer characters, phrases are kept as is
+	MinTokenLength int
+	// NoPrefix disables prefix matching of words not ending with *
+	NoPrefix bool
+	// RequireAll makes every clause without an operator required
+	RequireAll bool
+}
+
+// ParseQuery parses a random query to a MySQL boolean mode full-text query
 func ParseQuery(query string, stopWords ...string) string {
-	searchQueries := strings.Split(query, " ")
-	parsedQueries := make([]string, 0, len(searchQueries))
-	for _, queryToken := range searchQueries {
-		if containStopWord(queryToken, stopWords) {
+	return ParseFullTextQuery(query, &QueryOptions{StopWords: stopWords}).MySQL()
+}
+
+// ParseFullTextQuery parses user input into a full-text query.
+//
+// The input supports quoted phrases, +required and -excluded terms, alternatives joined with OR or |
+// and trailing * for prefix matching. Words are split on any character that is not a Unicode letter,
+// digit or mark, so operator characters in user input never reach the database.
+func ParseFullTextQuery(query string, opt *QueryOptions) *FullTextQuery {
+	if opt == nil {
+		opt = &QueryOptions{}
+	}
+
+	p := &queryParser{input: query, opt: opt}
+
+	return p.parse()
+}
+
+type queryParser struct {
+	input string
+	pos   int
+	opt   *QueryOptions
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+func (p *queryParser) peek() (rune, int) {
+	if p.pos >= len(p.input) {
+		return utf8.RuneError, 0
+	}
+	return utf8.DecodeRuneInString(p.input[p.pos:])
+}
+
+func (p *queryParser) parse() *FullTextQuery {
+	var (
+		q         = &FullTextQuery{}
+		orPending bool
+	)
+
+	for p.pos < len(p.input) {
+		r, size := p.peek()
+
+		switch {
+		case unicode.IsSpace(r):
+			p.pos += size
+			continue
+		case r == '|':
+			p.pos += size
+			orPending = len(q.Clauses) > 0
 			continue
 		}
-		parsedQueries = append(parsedQueries, queryToken+"*")
+
+		operator := Optional
+		if (r == '+' || r == '-') && p.atTokenStart() {
+			p.pos += size
+			next, nextSize := p.peek()
+			if next != '"' && !isWordRune(next) {
+				continue
+			}
+			if r == '+' {
+				operator = Required
+			} else {
+				operator = Excluded
+			}
+			r, size = next, nextSize
+		}
+
+		var terms []*QueryTerm
+
+		switch {
+		case r == '"':
+			p.pos += size
+			terms = p.phrase()
+		case isWordRune(r):
+			word, prefix := p.word()
+			if word == "OR" && len(q.Clauses) > 0 {
+				orPending = true
+				continue
+			}
+			for _, w := range splitWords(word) {
+				if p.keep(w) {
+					terms = append(terms, &QueryTerm{Words: []string{w}, Prefix: prefix || !p.opt.NoPrefix})
+				}
+			}
+		default:
+			// Strip operator and punctuation characters
+			p.pos += size
+			continue
+		}
+
+		if len(terms) == 0 {
+			continue
+		}
+
+		if orPending {
+			last := q.Clauses[len(q.Clauses)-1]
+			last.Terms = append(last.Terms, terms...)
+			orPending = false
+			continue
+		}
+
+		if operator == Optional && p.opt.RequireAll {
+			operator = Required
+		}
+
+		for _, term := range terms {
+			q.Clauses = append(q.Clauses, &QueryClause{Operator: operator, Terms: []*QueryTerm{term}})
+		}
+	}
+
+	return q
+}
+
+// atTokenStart checks whether the current position starts a new token
+func (p *queryParser) atTokenStart() bool {
+	if p.pos == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(p.input[:p.pos])
+	return unicode.IsSpace(prev) || prev == '(' || prev == '|'
+}
+
+// phrase reads words up to the closing quote
+func (p *queryParser) phrase() []*QueryTerm {
+	end := strings.IndexRune(p.input[p.pos:], '"')
+	if end < 0 {
+		end = len(p.input) - p.pos
+	}
+
+	words := splitWords(p.input[p.pos : p.pos+end])
+
+	p.pos += end + 1
+
+	switch len(words) {
+	case 0:
+		return nil
+	case 1:
+		return []*QueryTerm{{Words: words}}
+	default:
+		return []*QueryTerm{{Words: words, Phrase: true}}
+	}
+}
+
+// word reads a run of word characters and whether it is followed by *
+func (p *queryParser) word() (string, bool) {
+	start := p.pos
+	for {
+		r, size := p.peek()
+		if size == 0 || !isWordRune(r) {
+			break
+		}
+		p.pos += size
+	}
+	word := p.input[start:p.pos]
+
+	if r, size := p.peek(); r == '*' {
+		p.pos += size
+		return word, true
+	}
+
+	return word, false
+}
+
+func (p *queryParser) keep(word string) bool {
+	if utf8.RuneCountInString(word) < p.opt.MinTokenLength {
+		return false
 	}
-	return "+" + strings.Join(parsedQueries, " ")
+	return !containStopWord(word, p.opt.StopWords)
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !isWordRune(r)
+	})
 }
 
 func containStopWord(token string, stopWords []string) bool {
@@ -25,3 +241,157 @@ func containStopWord(token string, stopWords []string) bool {
 	}
 	return false
 }
+
+// Empty checks whether the query has no terms that can match
+func (q *FullTextQuery) Empty() bool {
+	for _, clause := range q.Clauses {
+		if clause.Operator != Excluded {
+			return false
+		}
+	}
+	return true
+}
+
+// Format formats the query for the dialect, it returns an empty string for an empty query
+func (q *FullTextQuery) Format(dialect string) string {
+	switch dialect {
+	case DialectPostgres:
+		return q.Postgres()
+	case DialectSQLite:
+		return q.SQLite()
+	default:
+		return q.MySQL()
+	}
+}
+
+// MySQL formats the query for MySQL MATCH ... AGAINST in boolean mode
+func (q *FullTextQuery) MySQL() string {
+	if q.Empty() {
+		return ""
+	}
+
+	format := func(term *QueryTerm) string {
+		if term.Phrase {
+			return `"` + strings.Join(term.Words, " ") + `"`
+		}
+		if term.Prefix {
+			return term.Words[0] + "*"
+		}
+		return term.Words[0]
+	}
+
+	parts := make([]string, 0, len(q.Clauses))
+	for _, clause := range q.Clauses {
+		var sb strings.Builder
+		switch clause.Operator {
+		case Required:
+			sb.WriteByte('+')
+		case Excluded:
+			sb.WriteByte('-')
+		}
+		sb.WriteString(joinTerms(clause.Terms, format, " ", "(", ")"))
+		parts = append(parts, sb.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Postgres formats the query for to_tsquery.
+//
+// Optional clauses are dropped when the query has required clauses since tsquery has no optional terms.
+func (q *FullTextQuery) Postgres() string {
+	format := func(term *QueryTerm) string {
+		if term.Phrase {
+			return "(" + strings.Join(term.Words, " <-> ") + ")"
+		}
+		if term.Prefix {
+			return term.Words[0] + ":*"
+		}
+		return term.Words[0]
+	}
+
+	required, optional, excluded := q.split(func(terms []*QueryTerm) string {
+		return joinTerms(terms, format, " | ", "(", ")")
+	})
+
+	positive := required
+	if len(positive) == 0 && len(optional) > 0 {
+		positive = []string{wrap(optional, " | ")}
+	}
+	if len(positive) == 0 {
+		return ""
+	}
+
+	for _, e := range excluded {
+		positive = append(positive, "!"+e)
+	}
+
+	return strings.Join(positive, " & ")
+}
+
+// SQLite formats the query for FTS5 MATCH.
+//
+// Optional clauses are dropped when the query has required clauses since FTS5 has no optional terms.
+func (q *FullTextQuery) SQLite() string {
+	format := func(term *QueryTerm) string {
+		quoted := `"` + strings.Join(term.Words, " ") + `"`
+		if term.Prefix && !term.Phrase {
+			return quoted + "*"
+		}
+		return quoted
+	}
+
+	required, optional, excluded := q.split(func(terms []*QueryTerm) string {
+		return joinTerms(terms, format, " OR ", "(", ")")
+	})
+
+	var positive string
+	switch {
+	case len(required) > 0:
+		positive = wrap(required, " AND ")
+	case len(optional) > 0:
+		positive = wrap(optional, " OR ")
+	default:
+		return ""
+	}
+
+	for _, e := range excluded {
+		positive += " NOT " + e
+	}
+
+	return positive
+}
+
+// split formats the clauses grouping them by operator
+func (q *FullTextQuery) split(format func([]*QueryTerm) string) (required, optional, excluded []string) {
+	for _, clause := range q.Clauses {
+		formatted := format(clause.Terms)
+		switch clause.Operator {
+		case Required:
+			required = append(required, formatted)
+		case Excluded:
+			excluded = append(excluded, formatted)
+		default:
+			optional = append(optional, formatted)
+		}
+	}
+	return required, optional, excluded
+}
+
+func joinTerms(terms []*QueryTerm, format func(*QueryTerm) string, sep, open, close string) string {
+	if len(terms) == 1 {
+		return format(terms[0])
+	}
+	formatted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		formatted = append(formatted, format(term))
+	}
+	return open + strings.Join(formatted, sep) + close
+}
+
+func wrap(parts []string, sep string) string {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
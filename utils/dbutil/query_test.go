@@ -24,3 +24,71 @@ func TestParseQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestParseFullTextQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		opt      *QueryOptions
+		mysql    string
+		postgres string
+		sqlite   string
+	}{
+		{
+			name:     "operators",
+			query:    `+hello -world "good friend"`,
+			mysql:    `+hello* -world* "good friend"`,
+			postgres: `hello:* & !world:*`,
+			sqlite:   `"hello"* NOT "world"*`,
+		},
+		{
+			name:     "or group",
+			query:    `+cats OR dogs | birds`,
+			mysql:    `+(cats* dogs* birds*)`,
+			postgres: `(cats:* | dogs:* | birds:*)`,
+			sqlite:   `("cats"* OR "dogs"* OR "birds"*)`,
+		},
+		{
+			name:     "strips operator characters",
+			query:    `a<b>(c)~d@e "f`,
+			opt:      &QueryOptions{NoPrefix: true},
+			mysql:    `a b c d e f`,
+			postgres: `(a | b | c | d | e | f)`,
+			sqlite:   `("a" OR "b" OR "c" OR "d" OR "e" OR "f")`,
+		},
+		{
+			name:     "hyphenated words and explicit prefix",
+			query:    `well-known ngo*`,
+			opt:      &QueryOptions{NoPrefix: true, RequireAll: true},
+			mysql:    `+well +known +ngo*`,
+			postgres: `well & known & ngo:*`,
+			sqlite:   `("well" AND "known" AND "ngo"*)`,
+		},
+		{
+			name:     "unicode and minimum token length",
+			query:    `café ab naïve 東京`,
+			opt:      &QueryOptions{MinTokenLength: 2, NoPrefix: true},
+			mysql:    `café ab naïve 東京`,
+			postgres: `(café | ab | naïve | 東京)`,
+			sqlite:   `("café" OR "ab" OR "naïve" OR "東京")`,
+		},
+		{
+			name:  "only excluded",
+			query: `-spam`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := ParseFullTextQuery(tt.query, tt.opt)
+			if got := q.Format(DialectMySQL); got != tt.mysql {
+				t.Errorf("MySQL() = %v, want %v", got, tt.mysql)
+			}
+			if got := q.Format(DialectPostgres); got != tt.postgres {
+				t.Errorf("Postgres() = %v, want %v", got, tt.postgres)
+			}
+			if got := q.Format(DialectSQLite); got != tt.sqlite {
+				t.Errorf("SQLite() = %v, want %v", got, tt.sqlite)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package dbutil
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type txCtxKey struct{}
+
+// TxOptions contains options for running a transaction
+type TxOptions struct {
+	// MaxRetries is how many times the transaction is retried on deadlock or serialization failure
+	MaxRetries int
+	// Backoff is the wait before the first retry, it doubles on every retry
+	Backoff time.Duration
+	// SQLOptions sets the isolation level and read only mode of the transaction
+	SQLOptions *sql.TxOptions
+}
+
+// DefaultTxOptions are the options used by WithTx
+var DefaultTxOptions = TxOptions{
+	MaxRetries: 3,
+	Backoff:    50 * time.Millisecond,
+}
+
+// WithTx runs fn in a transaction stored in the context passed to fn.
+//
+// Nested calls with that context join the transaction using a savepoint instead of starting a new one,
+// and repositories can use DB to pick up the transaction from the context.
+// The transaction is rolled back if fn returns an error or panics, and the whole of fn is retried with
+// backoff on deadlock or serialization failure.
+func WithTx(ctx context.Context, db *gorm.DB, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	return WithTxOptions(ctx, db, &DefaultTxOptions, fn)
+}
+
+// WithTxOptions works like WithTx but with custom transaction options
+func WithTxOptions(ctx context.Context, db *gorm.DB, opt *TxOptions, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	if opt == nil {
+		opt = &DefaultTxOptions
+	}
+
+	// Join the transaction in the context using a savepoint
+	if tx, ok := ctx.Value(txCtxKey{}).(*gorm.DB); ok {
+		return tx.Transaction(func(tx *gorm.DB) error {
+			return fn(context.WithValue(ctx, txCtxKey{}, tx), tx)
+		})
+	}
+
+	backoff := opt.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(context.WithValue(ctx, txCtxKey{}, tx), tx)
+		}, opt.SQLOptions)
+		if err == nil || attempt >= opt.MaxRetries || !IsRetryable(err) {
+			return err
+		}
+
+		// Wait with jitter before retrying
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+	}
+}
+
+// DB returns the transaction stored in the context by WithTx or db bound to ctx when there is none
+func DB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txCtxKey{}).(*gorm.DB); ok {
+		return tx
+	}
+	return db.WithContext(ctx)
+}
+
+// IsRetryable checks whether the error is a deadlock, serialization failure or lock timeout
+// after which the transaction can be retried
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch Classify(err).Kind {
+	case KindDeadlock, KindLockTimeout:
+		return true
+	}
+	return false
+}
@@ -3,6 +3,8 @@ package errs
 import (
 	"fmt"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -131,3 +133,24 @@ func WrapMessage(code codes.Code, msg string) error {
 func WrapMessagef(code codes.Code, format string, args ...interface{}) error {
 	return status.Error(code, fmt.Sprintf(format, args...))
 }
+
+// FieldViolation returns a status error with a bad request detail describing the violation on field
+func FieldViolation(code codes.Code, field, description string) error {
+	msg := description
+	if field != "" {
+		msg = fmt.Sprintf("%s: %s", field, description)
+	}
+	st := status.New(code, msg)
+	if field == "" {
+		return st.Err()
+	}
+	detailed, err := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: description},
+		},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
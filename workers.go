@@ -0,0 +1,40 @@
+package gomicro
+
+import (
+	"context"
+	"sync"
+)
+
+// AddWorkers adds background workers that are started when the service starts serving.
+//
+// Workers should run until their context is cancelled, which happens when the service shuts down.
+// A worker returning an error is logged and not restarted.
+func (service *Service) AddWorkers(workers ...func(context.Context) error) {
+	service.workers = append(service.workers, workers...)
+}
+
+// startWorkers starts the background workers and registers a shutdown that stops them
+func (service *Service) startWorkers(ctx context.Context) {
+	if len(service.workers) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	wg := &sync.WaitGroup{}
+
+	for _, worker := range service.workers {
+		wg.Add(1)
+		go func(worker func(context.Context) error) {
+			defer wg.Done()
+			if err := worker(ctx); err != nil && ctx.Err() == nil {
+				service.options.Logger.Errorf("background worker failed: %v", err)
+			}
+		}(worker)
+	}
+
+	service.shutdowns = append(service.shutdowns, func() error {
+		cancel()
+		wg.Wait()
+		return nil
+	})
+}